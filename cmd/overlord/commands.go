@@ -0,0 +1,271 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"maps"
+	"os"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/AirVantage/overlord/pkg/engine"
+	"github.com/AirVantage/overlord/pkg/inventory"
+	"github.com/AirVantage/overlord/pkg/lookable"
+	"github.com/AirVantage/overlord/pkg/state"
+	"github.com/pmezard/go-difflib/difflib"
+)
+
+// Exit codes of the commands
+const (
+	exitOK = 0
+	// Invalid configuration, failed lookup or render
+	exitError = 1
+	exitUsage = 2
+	// The iteration completed but a reload failed
+	exitReloadFailed = 3
+)
+
+// Run a single iteration.
+func once(ctx context.Context) int {
+	reconciler, err := newReconciler(ctx, nil)
+	if err != nil {
+		slog.Error(err.Error())
+		return exitError
+	}
+
+	result, err := reconciler.RunOnce(ctx)
+	if err != nil {
+		logError(err)
+		return exitError
+	}
+	saveState(result)
+
+	for _, reload := range result.Reloads {
+		if reload.Status.Failed() {
+			return exitReloadFailed
+		}
+	}
+	return exitOK
+}
+
+// Print the diff of the dest files and the reloads an iteration would make, without touching anything.
+// Changes are relative to the state saved by the last iteration, or to an empty state.
+func plan(ctx context.Context) int {
+	reconciler, err := newReconciler(ctx, nil)
+	if err != nil {
+		slog.Error(err.Error())
+		return exitError
+	}
+
+	snapshot, err := state.Load(*stateFile)
+	if err == nil {
+		reconciler.SetState(snapshot.State())
+	} else if !errors.Is(err, os.ErrNotExist) {
+		slog.Warn("Ignoring saved state", "state_file", *stateFile, "error", err)
+	}
+
+	result, err := reconciler.Plan(ctx)
+	if err != nil {
+		logError(err)
+		return exitError
+	}
+
+	for _, diff := range result.Diffs {
+		text, err := difflib.GetUnifiedDiffString(difflib.UnifiedDiff{
+			A:        difflib.SplitLines(diff.Current),
+			B:        difflib.SplitLines(diff.Rendered),
+			FromFile: diff.Resource.Dest,
+			ToFile:   diff.Resource.Dest + " (" + diff.Resource.Src + ")",
+			Context:  3,
+		})
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return exitError
+		}
+		fmt.Print(text)
+	}
+
+	for _, reload := range result.Reloads {
+		templates := make([]string, 0, len(reload.Resources))
+		for _, resource := range reload.Resources {
+			templates = append(templates, resource.Src)
+		}
+		added := reload.Changes.Added()
+		removed := reload.Changes.Removed()
+		sort.Strings(added)
+		sort.Strings(removed)
+
+		fmt.Printf("reload %s: %s\n", strings.Join(templates, ","), reload.Command)
+		fmt.Printf("  IP_ADDED=%s\n", strings.Join(added, " "))
+		fmt.Printf("  IP_REMOVED=%s\n", strings.Join(removed, " "))
+	}
+
+	if len(result.Diffs) == 0 && len(result.Reloads) == 0 {
+		fmt.Println("No changes")
+	}
+	return exitOK
+}
+
+// Check the resources and templates without looking anything up.
+func validate() int {
+	err := engine.New(engine.Config{Dir: *configRoot}).Validate()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitError
+	}
+	return exitOK
+}
+
+// Print the template of a resource rendered with the current IPs of its lookables.
+func render(ctx context.Context, args []string) int {
+	if len(args) != 1 {
+		fmt.Fprintln(os.Stderr, "usage: overlord render <resource>")
+		return exitUsage
+	}
+
+	if *fixtureFile != "" {
+		ips, err := loadFixture()
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return exitError
+		}
+		if err := engine.New(engine.Config{Dir: *configRoot}).RenderFixture(args[0], ips, os.Stdout); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return exitError
+		}
+		return exitOK
+	}
+
+	reconciler, err := newReconciler(ctx, nil)
+	if err != nil {
+		slog.Error(err.Error())
+		return exitError
+	}
+
+	if err := reconciler.Render(ctx, args[0], os.Stdout); err != nil {
+		logError(err)
+		return exitError
+	}
+	return exitOK
+}
+
+// Compare the templates rendered from their fixture with their golden file, printing the diff of the failed ones.
+func test() int {
+	var ips inventory.Inventory
+	if *fixtureFile != "" {
+		var err error
+		if ips, err = loadFixture(); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return exitError
+		}
+	}
+
+	results, err := engine.New(engine.Config{Dir: *configRoot}).Test(ips, *update)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitError
+	}
+
+	code := exitOK
+	for _, golden := range results {
+		switch {
+		case golden.Updated:
+			fmt.Printf("updated %s\n", golden.Path)
+		case golden.Passed():
+			fmt.Printf("ok      %s\n", golden.Resource.Src)
+		default:
+			fmt.Printf("FAIL    %s\n", golden.Resource.Src)
+			text, err := difflib.GetUnifiedDiffString(difflib.UnifiedDiff{
+				A:        difflib.SplitLines(golden.Expected),
+				B:        difflib.SplitLines(golden.Rendered),
+				FromFile: golden.Path,
+				ToFile:   golden.Resource.Src,
+				Context:  3,
+			})
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				return exitError
+			}
+			fmt.Print(text)
+			code = exitError
+		}
+	}
+	if len(results) == 0 {
+		fmt.Println("no golden file")
+	}
+	return code
+}
+
+// Read the fixture given with -fixture.
+func loadFixture() (inventory.Inventory, error) {
+	content, err := os.ReadFile(*fixtureFile)
+	if err != nil {
+		return nil, err
+	}
+	return inventory.Parse(*fixtureFile, content)
+}
+
+// Print the sorted members of a lookable, one per line: IP, then port and metadata when known.
+func lookup(ctx context.Context, args []string) int {
+	if len(args) != 2 {
+		fmt.Fprintln(os.Stderr, "usage: overlord lookup <group|tag|subnet|file|dns|srv|target_group|ecs_service|cloud_map|consul|kubernetes|docker|exec|http> <name>")
+		return exitUsage
+	}
+
+	l, err := lookable.Parse(args[0], args[1])
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitUsage
+	}
+
+	cfg, err := loadAWSConfig(ctx)
+	if err != nil {
+		slog.Error("unable to initialize AWS SDK v2", "detail", err)
+		return exitError
+	}
+
+	members, err := lookable.Lookup(ctx, l, lookable.NewClients(cfg), *ipv6)
+	if err != nil {
+		logError(err)
+		return exitError
+	}
+
+	for _, member := range members {
+		fields := []string{member.IP}
+		if member.Port != 0 {
+			fields = append(fields, strconv.Itoa(member.Port))
+		}
+		keys := slices.Sorted(maps.Keys(member.Meta))
+		for _, key := range keys {
+			fields = append(fields, key+"="+member.Meta[key])
+		}
+		fmt.Println(strings.Join(fields, " "))
+	}
+	return exitOK
+}
+
+// Print the state saved by the last iteration, as JSON.
+func dumpState() int {
+	snapshot, err := state.Load(*stateFile)
+	if errors.Is(err, os.ErrNotExist) {
+		fmt.Fprintf(os.Stderr, "no state saved in %s yet\n", *stateFile)
+		return exitError
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitError
+	}
+
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(snapshot); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitError
+	}
+	return exitOK
+}
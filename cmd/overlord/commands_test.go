@@ -0,0 +1,211 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"testing"
+)
+
+func TestParseArgs(t *testing.T) {
+
+	cases := []struct {
+		arguments []string
+		expect    []string
+		fixture   string
+		update    bool
+	}{
+		/* Flags before the command */
+		{
+			arguments: []string{"-fixture", "f.json", "render", "haproxy"},
+			expect:    []string{"render", "haproxy"},
+			fixture:   "f.json",
+		},
+		/* Flags after the command and its arguments */
+		{
+			arguments: []string{"render", "haproxy", "-fixture", "f.json"},
+			expect:    []string{"render", "haproxy"},
+			fixture:   "f.json",
+		},
+		/* Flags between the command and its arguments */
+		{
+			arguments: []string{"test", "-update", "x"},
+			expect:    []string{"test", "x"},
+			update:    true,
+		},
+		/* Arguments after -- kept as is */
+		{
+			arguments: []string{"lookup", "--", "exec", "-update"},
+			expect:    []string{"lookup", "exec", "-update"},
+		},
+		/* No command */
+		{
+			arguments: []string{"-update"},
+			update:    true,
+		},
+	}
+
+	for i, tt := range cases {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			flags := flag.NewFlagSet("overlord", flag.ContinueOnError)
+			fixture := flags.String("fixture", "", "")
+			update := flags.Bool("update", false, "")
+
+			args, err := parseArgs(flags, tt.arguments)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(args, tt.expect) {
+				t.Errorf("expect %v, got %v", tt.expect, args)
+			}
+			if *fixture != tt.fixture || *update != tt.update {
+				t.Errorf("expect fixture %q and update %v, got %q and %v", tt.fixture, tt.update, *fixture, *update)
+			}
+		})
+	}
+
+	// Unknown flags are rejected wherever they are
+	flags := flag.NewFlagSet("overlord", flag.ContinueOnError)
+	flags.SetOutput(io.Discard)
+	if _, err := parseArgs(flags, []string{"render", "haproxy", "-unknown"}); err == nil {
+		t.Error("expect an error for an unknown flag")
+	}
+}
+
+func TestCommands(t *testing.T) {
+
+	cases := []struct {
+		// Settings added to the resource watching the members file
+		settings string
+		golden   string
+		// Whether -fixture gives 10.0.0.9 as the only member
+		fixture bool
+		update  bool
+		command func(ctx context.Context) int
+		expect  int
+		// Content of the golden file after the command, unchanged when empty
+		expectGolden string
+	}{
+		/* Valid configuration */
+		{
+			command: func(ctx context.Context) int { return validate() },
+			expect:  exitOK,
+		},
+		/* Invalid configuration */
+		{
+			settings: "reload_signal = \"SIGHUP\"\n",
+			command:  func(ctx context.Context) int { return validate() },
+			expect:   exitError,
+		},
+		/* Successful reload */
+		{
+			settings: "reload_cmd = \"true\"\n",
+			command:  once,
+			expect:   exitOK,
+		},
+		/* Failed reload */
+		{
+			settings: "reload_cmd = \"exit 1\"\n",
+			command:  once,
+			expect:   exitReloadFailed,
+		},
+		/* Render from a fixture */
+		{
+			fixture: true,
+			command: func(ctx context.Context) int { return render(ctx, []string{"web"}) },
+			expect:  exitOK,
+		},
+		/* Render without resource */
+		{
+			command: func(ctx context.Context) int { return render(ctx, nil) },
+			expect:  exitUsage,
+		},
+		/* Golden file matching the fixture */
+		{
+			golden:  "server 10.0.0.9\n",
+			fixture: true,
+			command: func(ctx context.Context) int { return test() },
+			expect:  exitOK,
+		},
+		/* Golden file differing from the fixture */
+		{
+			golden:  "server 10.0.0.1\n",
+			fixture: true,
+			command: func(ctx context.Context) int { return test() },
+			expect:  exitError,
+		},
+		/* Golden file updated from the fixture */
+		{
+			golden:       "server 10.0.0.1\n",
+			fixture:      true,
+			update:       true,
+			command:      func(ctx context.Context) int { return test() },
+			expect:       exitOK,
+			expectGolden: "server 10.0.0.9\n",
+		},
+		/* Unknown lookable kind */
+		{
+			command: func(ctx context.Context) int { return lookup(ctx, []string{"unknown", "web"}) },
+			expect:  exitUsage,
+		},
+		/* No saved state */
+		{
+			command: func(ctx context.Context) int { return dumpState() },
+			expect:  exitError,
+		},
+	}
+
+	for i, tt := range cases {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			dir := t.TempDir()
+			members := filepath.Join(dir, "members.txt")
+			golden := filepath.Join(dir, "etc", "templates", "web.tmpl.golden")
+			files := map[string]string{
+				members: "10.0.0.1\n",
+				filepath.Join(dir, "etc", "resources", "web.toml"): "[template]\nsrc = \"web.tmpl\"\ndest = \"" + filepath.Join(dir, "web.conf") + "\"\n" +
+					"files = [\"" + members + "\"]\n" + tt.settings,
+				filepath.Join(dir, "etc", "templates", "web.tmpl"): "{{range index . \"" + members + "\"}}server {{.}}\n{{end}}",
+			}
+			if tt.golden != "" {
+				files[golden] = tt.golden
+			}
+			if tt.fixture {
+				files[filepath.Join(dir, "fixture.yaml")] = "\"" + members + "\": [10.0.0.9]\n"
+			}
+			for name, content := range files {
+				if err := os.MkdirAll(filepath.Dir(name), 0755); err != nil {
+					t.Fatal(err)
+				}
+				if err := os.WriteFile(name, []byte(content), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			*configRoot = filepath.Join(dir, "etc")
+			*backupDir = filepath.Join(dir, "backups")
+			*stateFile = filepath.Join(dir, "state.json")
+			*fixtureFile = ""
+			if tt.fixture {
+				*fixtureFile = filepath.Join(dir, "fixture.yaml")
+			}
+			*update = tt.update
+
+			if code := tt.command(context.Background()); code != tt.expect {
+				t.Errorf("expect exit code %d, got %d", tt.expect, code)
+			}
+			if tt.expectGolden != "" {
+				content, err := os.ReadFile(golden)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if string(content) != tt.expectGolden {
+					t.Errorf("expect %q, got %q", tt.expectGolden, content)
+				}
+			}
+		})
+	}
+}
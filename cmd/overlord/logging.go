@@ -1,6 +1,7 @@
 package main
 
 import (
+	"io"
 	"log/slog"
 	"log/syslog"
 	"os"
@@ -32,7 +33,8 @@ func removeTimeAttr(groups []string, a slog.Attr) slog.Attr {
 	return a
 }
 
-func InitLog() {
+// InitLog sends logs to output, and to syslog when SYSLOG_ADDRESS is set.
+func InitLog(output io.Writer) {
 	var (
 		syslogCfg string
 		handlers  []slog.Handler
@@ -43,9 +45,9 @@ func InitLog() {
 		loggerLevel = slog.LevelDebug
 	}
 
-	// Always add stdout handler with time attribute removed
+	// Always add output handler with time attribute removed
 	handlers = append(handlers,
-		slog.NewTextHandler(os.Stdout, &slog.HandlerOptions{
+		slog.NewTextHandler(output, &slog.HandlerOptions{
 			Level:       loggerLevel,
 			ReplaceAttr: removeTimeAttr,
 		}),
@@ -4,14 +4,20 @@ import (
 	"context"
 	"errors"
 	"flag"
+	"fmt"
+	"io"
 	"log/slog"
+	"net"
 	"os"
 	"os/signal"
 	"syscall"
 
 	"time"
 
-	"github.com/AirVantage/overlord/pkg/state"
+	"github.com/AirVantage/overlord/pkg/engine"
+	"github.com/AirVantage/overlord/pkg/lookable"
+	"github.com/AirVantage/overlord/pkg/sdnotify"
+	"github.com/AirVantage/overlord/pkg/xds"
 	"github.com/aws/aws-sdk-go-v2/aws"
 	awsretry "github.com/aws/aws-sdk-go-v2/aws/retry"
 	"github.com/aws/aws-sdk-go-v2/config"
@@ -19,42 +25,114 @@ import (
 )
 
 var (
-	configRoot       = flag.String("etc", "/etc/overlord", "path to configuration directory")
-	resourcesDirName = "resources"
-	templatesDirName = "templates"
-	interval         = flag.Duration("interval", 30*time.Second, "Interval between each lookup")
-	ipv6             = flag.Bool("ipv6", false, "Look for IPv6 addresses instead of IPv4")
-	verboseLog       = flag.Bool("v", false, "verbose debug information")
+	configRoot  = flag.String("etc", "/etc/overlord", "path to configuration directory")
+	backupDir   = flag.String("backup-dir", "/var/lib/overlord/backups", "path to the directory keeping previous versions of generated files")
+	stateFile   = flag.String("state-file", "/var/lib/overlord/state.json", "path to the file saving the state of the last iteration")
+	interval    = flag.Duration("interval", 30*time.Second, "Interval between each lookup")
+	ipv6        = flag.Bool("ipv6", false, "Look for IPv6 addresses instead of IPv4")
+	verboseLog  = flag.Bool("v", false, "verbose debug information")
+	xdsListen   = flag.String("xds-listen", "", "address to serve Envoy endpoint discovery on, disabled when empty")
+	xdsPort     = flag.Uint("xds-port", 80, "port of the endpoints served to Envoy, when their members have no port")
+	fixtureFile = flag.String("fixture", "", "JSON or YAML file mapping lookables to IPs, used by render and test instead of looking them up")
+	update      = flag.Bool("update", false, "write the golden files with the rendered templates, for test")
 )
 
-func main() {
-	var (
-		cfg          aws.Config
-		ctx          context.Context = context.TODO()
-		runningState *state.State    = state.New()
-		err          error
-	)
+const usage = `Usage: overlord [flags] [command] [arguments]
+
+Commands:
+  run                   look up, render and reload every interval (default)
+  once                  run a single iteration and exit
+  plan                  print the changes and reloads an iteration would make, without touching anything
+  validate              check the resources and templates
+  render <resource>     print the template of a resource rendered with the current IPs, or the ones of -fixture
+  test                  compare the templates rendered from their fixture with their golden file
+  lookup <kind> <name>  print the members of a group, tag, subnet, file, DNS name, target group, ECS, Cloud Map, Consul or Kubernetes service, Docker containers, or a plugin
+  state                 print the state saved by the last iteration
+
+Flags:
+`
 
+func main() {
 	// Handle termination signals
 	termSig := make(chan os.Signal, 1)
 	signal.Notify(termSig, os.Interrupt, syscall.SIGTERM, syscall.SIGQUIT)
 	go func() {
 		sig := <-termSig
 		slog.Error("Received termination signal", "signal", sig)
+		notify(sdnotify.Stopping)
 		os.Exit(1)
 	}()
 
-	// Handle SIGHUP for configuration reload
-	hupSig := make(chan os.Signal, 1)
-	signal.Notify(hupSig, syscall.SIGHUP)
+	flag.Usage = func() {
+		fmt.Fprint(flag.CommandLine.Output(), usage)
+		flag.PrintDefaults()
+	}
+	// Flags are accepted before, between and after the command and its arguments
+	command := "run"
+	args, err := parseArgs(flag.CommandLine, os.Args[1:])
+	if err != nil {
+		os.Exit(exitUsage)
+	}
+	if len(args) > 0 {
+		command = args[0]
+		args = args[1:]
+	}
 
-	flag.Parse()
-	InitLog()
+	// Keep stdout for the output of the commands other than run
+	var logOutput io.Writer = os.Stderr
+	if command == "run" {
+		logOutput = os.Stdout
+	}
+	InitLog(logOutput)
 
-	slog.Info("overlord starting", "version", Version, "commit", Commit, "date", Time)
+	ctx := context.TODO()
+	switch command {
+	case "run":
+		run(ctx)
+	case "once":
+		os.Exit(once(ctx))
+	case "plan":
+		os.Exit(plan(ctx))
+	case "validate":
+		os.Exit(validate())
+	case "render":
+		os.Exit(render(ctx, args))
+	case "test":
+		os.Exit(test())
+	case "lookup":
+		os.Exit(lookup(ctx, args))
+	case "state":
+		os.Exit(dumpState())
+	default:
+		fmt.Fprintf(os.Stderr, "unknown command %s\n\n", command)
+		flag.Usage()
+		os.Exit(exitUsage)
+	}
+}
 
-	// Initialise AWS SDK v2, process default configuration with retry configuration
-	cfg, err = config.LoadDefaultConfig(ctx,
+// Parse the flags of arguments wherever they are, and return the other arguments.
+// Arguments following -- are never parsed as flags.
+func parseArgs(flags *flag.FlagSet, arguments []string) ([]string, error) {
+	var positional []string
+	for {
+		if err := flags.Parse(arguments); err != nil {
+			return nil, err
+		}
+		rest := flags.Args()
+		if len(rest) == 0 {
+			return positional, nil
+		}
+		if parsed := len(arguments) - len(rest); parsed > 0 && arguments[parsed-1] == "--" {
+			return append(positional, rest...), nil
+		}
+		positional = append(positional, rest[0])
+		arguments = rest[1:]
+	}
+}
+
+// Initialise AWS SDK v2, process default configuration with retry configuration
+func loadAWSConfig(ctx context.Context) (aws.Config, error) {
+	return config.LoadDefaultConfig(ctx,
 		config.WithRetryer(func() aws.Retryer {
 			return awsretry.NewAdaptiveMode(func(o *awsretry.AdaptiveModeOptions) {
 				// Configure standard retry options
@@ -70,38 +148,114 @@ func main() {
 			})
 		}),
 	)
+}
+
+// Create a Reconciler from the flags, with AWS clients when lookups are needed.
+func newReconciler(ctx context.Context, onEvent func(engine.Event)) (*engine.Reconciler, error) {
+	cfg, err := loadAWSConfig(ctx)
 	if err != nil {
-		slog.Error("unable to initialize AWS SDK v2", "detail", err)
-		os.Exit(1)
+		return nil, fmt.Errorf("unable to initialize AWS SDK v2: %w", err)
 	}
 
-	// Main loop
-	for {
-		runningState, err = Iterate(ctx, cfg, runningState, hupSig)
-		if err != nil {
-			var oe *smithy.OperationError
-			var ae smithy.APIError
-
-			if errors.As(err, &oe) {
-				slog.Error("Failed service call processing ..", "service", oe.Service(), "operation", oe.Operation(), "error", oe.Unwrap().Error())
-			} else {
-				if errors.As(err, &ae) {
-					slog.Error("AWS API Error detail", "code", ae.ErrorCode(), "message", ae.ErrorMessage(), "fault", ae.ErrorFault().String())
-				} else {
-					slog.Error(err.Error())
-				}
-			}
+	return engine.New(engine.Config{
+		Dir:       *configRoot,
+		BackupDir: *backupDir,
+		Interval:  *interval,
+		IPv6:      *ipv6,
+		Clients:   lookable.NewClients(cfg),
+		OnEvent:   onEvent,
+	}), nil
+}
 
+// Log an error, with the details of AWS service errors.
+func logError(err error) {
+	var oe *smithy.OperationError
+	var ae smithy.APIError
+
+	if errors.As(err, &oe) {
+		slog.Error("Failed service call processing ..", "service", oe.Service(), "operation", oe.Operation(), "error", oe.Unwrap().Error())
+	} else {
+		if errors.As(err, &ae) {
+			slog.Error("AWS API Error detail", "code", ae.ErrorCode(), "message", ae.ErrorMessage(), "fault", ae.ErrorFault().String())
+		} else {
+			slog.Error(err.Error())
+		}
+	}
+}
+
+// Save the state of an iteration, for the state command.
+func saveState(result *engine.Result) {
+	if err := result.State.Snapshot(time.Now()).Save(*stateFile); err != nil {
+		slog.Warn("Unable to save state", "state_file", *stateFile, "error", err)
+	}
+}
+
+// Look up, render and reload every interval, until a termination signal or an error.
+func run(ctx context.Context) {
+	var (
+		eds     *xds.Server
+		systemd notifier
+	)
+
+	// Handle SIGHUP for configuration reload
+	hupSig := make(chan os.Signal, 1)
+	signal.Notify(hupSig, syscall.SIGHUP)
+
+	slog.Info("overlord starting", "version", Version, "commit", Commit, "date", Time)
+
+	// Serve lookables to Envoy
+	if *xdsListen != "" {
+		listener, err := net.Listen("tcp", *xdsListen)
+		if err != nil {
+			slog.Error("unable to listen for Envoy endpoint discovery", "detail", err)
 			os.Exit(1)
 		}
+		eds = xds.New(uint32(*xdsPort))
+		go func() {
+			if err := eds.Serve(ctx, listener); err != nil {
+				slog.Error("Envoy endpoint discovery server failed", "detail", err)
+				os.Exit(1)
+			}
+		}()
+	}
+
+	// Ping systemd watchdog if enabled
+	watchdogTimeout, err := sdnotify.WatchdogInterval()
+	if err != nil {
+		slog.Warn("Ignoring systemd watchdog", "detail", err)
+	} else if watchdogTimeout > 0 {
+		go systemd.watchdog(ctx, watchdogTimeout)
+	}
 
-		// Sleep for the configured interval, but wake up immediately on SIGHUP
-		select {
-		case <-time.After(*interval):
-			// Normal interval elapsed, continue to next iteration
-		case <-hupSig:
-			slog.Info("Received SIGHUP, interrupting sleep for immediate iteration")
-			// SIGHUP received, skip remaining sleep time and iterate immediately
+	reconciler, err := newReconciler(ctx, func(event engine.Event) {
+		switch event.Type {
+		case engine.IterationStarted:
+			systemd.startIteration()
+		case engine.IterationDone:
+			if eds != nil {
+				if err := eds.Update(ctx, event.Result.State.GroupMembers()); err != nil {
+					slog.Error("unable to update Envoy endpoints", "detail", err)
+					os.Exit(1)
+				}
+			}
+			saveState(event.Result)
+			systemd.endIteration(event.Result.State)
 		}
+	})
+	if err != nil {
+		slog.Error(err.Error())
+		os.Exit(1)
 	}
+
+	// SIGHUP forces a configuration reload, or skips the remaining sleep time
+	go func() {
+		for range hupSig {
+			slog.Info("Received SIGHUP")
+			reconciler.Trigger()
+		}
+	}()
+
+	// Main loop
+	logError(reconciler.Run(ctx))
+	os.Exit(1)
 }
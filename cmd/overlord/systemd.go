@@ -0,0 +1,71 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sync/atomic"
+	"time"
+
+	"github.com/AirVantage/overlord/pkg/sdnotify"
+	"github.com/AirVantage/overlord/pkg/state"
+)
+
+// notifier reports readiness, status and liveness of the main loop to systemd.
+type notifier struct {
+	ready bool
+	// Start time of the running iteration in Unix nanoseconds, 0 between iterations
+	iterationStart atomic.Int64
+}
+
+// Send notifications to systemd, logging failures.
+func notify(states ...string) {
+	if _, err := sdnotify.Notify(states...); err != nil {
+		slog.Warn("Unable to notify systemd", "error", err)
+	}
+}
+
+// Mark the start of an iteration.
+func (n *notifier) startIteration() {
+	n.iterationStart.Store(time.Now().UnixNano())
+}
+
+// Mark the end of a successful iteration: overlord is ready once the first one rendered the resources.
+func (n *notifier) endIteration(s *state.State) {
+	n.iterationStart.Store(0)
+
+	ips := 0
+	for _, ipset := range s.Ipsets {
+		ips += len(*ipset)
+	}
+	status := sdnotify.Status(fmt.Sprintf("%d resources, %d groups, %d IPs", len(s.Templates), len(s.Ipsets), ips))
+
+	if !n.ready {
+		n.ready = true
+		notify(sdnotify.Ready, status)
+		return
+	}
+	notify(status)
+}
+
+// Ping the systemd watchdog while the main loop makes progress, that is while it is waiting
+// between iterations or the running iteration has not lasted longer than the watchdog timeout.
+func (n *notifier) watchdog(ctx context.Context, timeout time.Duration) {
+	ticker := time.NewTicker(timeout / 2)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		start := n.iterationStart.Load()
+		if start != 0 && time.Since(time.Unix(0, start)) > timeout {
+			slog.Warn("Iteration is taking longer than the watchdog timeout", "since", time.Unix(0, start))
+			continue
+		}
+		notify(sdnotify.Watchdog)
+	}
+}
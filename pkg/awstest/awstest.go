@@ -0,0 +1,330 @@
+package awstest
+
+// In-memory fake of the EC2 and Auto Scaling APIs used by lookables
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"sort"
+	"strconv"
+	"sync"
+
+	"github.com/AirVantage/overlord/pkg/lookable"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/autoscaling"
+	asgtypes "github.com/aws/aws-sdk-go-v2/service/autoscaling/types"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	ec2types "github.com/aws/aws-sdk-go-v2/service/ec2/types"
+	"github.com/aws/smithy-go"
+)
+
+// Instance is a fake EC2 instance.
+type Instance struct {
+	ID          string
+	PrivateIP   string
+	IPv6        string
+	Name        string
+	SubnetID    string
+	State       ec2types.InstanceStateName
+	Group       string
+	Lifecycle   asgtypes.LifecycleState
+	launchOrder int
+}
+
+// Cloud is a fake of the EC2 and Auto Scaling APIs, implementing lookable.EC2API and lookable.ASGAPI.
+// It is safe for concurrent use, scenarios may be scripted while lookups run.
+type Cloud struct {
+	mu        sync.Mutex
+	instances map[string]*Instance
+	// Subnet ids by name
+	subnets  map[string]string
+	launched int
+	// Number of results per page, all of them when 0
+	pageSize int
+	// Errors returned by the next calls, in order
+	failures []error
+	calls    map[string]int
+}
+
+// New returns a pointer to an empty Cloud.
+func New() *Cloud {
+	return &Cloud{
+		instances: make(map[string]*Instance),
+		subnets:   make(map[string]string),
+		calls:     make(map[string]int),
+	}
+}
+
+// Clients returns lookable clients backed by the Cloud.
+func (c *Cloud) Clients() *lookable.Clients {
+	return &lookable.Clients{EC2: c, ASG: c}
+}
+
+// AddSubnet declares a subnet named name.
+func (c *Cloud) AddSubnet(name, id string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.subnets[name] = id
+}
+
+// Launch a running instance, InService in its Auto Scaling group when it belongs to one.
+func (c *Cloud) Launch(instance Instance) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.launched++
+	instance.launchOrder = c.launched
+	if instance.ID == "" {
+		instance.ID = fmt.Sprintf("i-%08d", c.launched)
+	}
+	if instance.State == "" {
+		instance.State = ec2types.InstanceStateNameRunning
+	}
+	if instance.Group != "" && instance.Lifecycle == "" {
+		instance.Lifecycle = asgtypes.LifecycleStateInService
+	}
+	c.instances[instance.ID] = &instance
+}
+
+// Terminate an instance, which also leaves its Auto Scaling group.
+func (c *Cloud) Terminate(id string) {
+	c.update(id, func(instance *Instance) {
+		instance.State = ec2types.InstanceStateNameTerminated
+		instance.Lifecycle = asgtypes.LifecycleStateTerminated
+	})
+}
+
+// SetLifecycle changes the lifecycle state of an instance in its Auto Scaling group, e.g. to Standby.
+func (c *Cloud) SetLifecycle(id string, lifecycle asgtypes.LifecycleState) {
+	c.update(id, func(instance *Instance) {
+		instance.Lifecycle = lifecycle
+	})
+}
+
+// SetState changes the EC2 state of an instance, e.g. to stopped.
+func (c *Cloud) SetState(id string, state ec2types.InstanceStateName) {
+	c.update(id, func(instance *Instance) {
+		instance.State = state
+	})
+}
+
+func (c *Cloud) update(id string, change func(*Instance)) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if instance, exists := c.instances[id]; exists {
+		change(instance)
+	}
+}
+
+// SetPageSize limits the number of results returned per page, 0 returns all of them at once.
+func (c *Cloud) SetPageSize(size int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.pageSize = size
+}
+
+// Fail makes the next calls return errs, one per call.
+func (c *Cloud) Fail(errs ...error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.failures = append(c.failures, errs...)
+}
+
+// Throttle makes the next n calls fail with a throttling error.
+func (c *Cloud) Throttle(n int) {
+	for i := 0; i < n; i++ {
+		c.Fail(&smithy.GenericAPIError{Code: "RequestLimitExceeded", Message: "Request limit exceeded.", Fault: smithy.FaultClient})
+	}
+}
+
+// Calls returns the number of calls to operation, e.g. "DescribeInstances", failed ones included.
+func (c *Cloud) Calls(operation string) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.calls[operation]
+}
+
+// Count a call and return the next scripted failure, wrapped the way the SDK does.
+// Must be called with the lock held.
+func (c *Cloud) call(service, operation string) error {
+	c.calls[operation]++
+	if len(c.failures) == 0 {
+		return nil
+	}
+	err := c.failures[0]
+	c.failures = c.failures[1:]
+	return &smithy.OperationError{ServiceID: service, OperationName: operation, Err: err}
+}
+
+// Instances sorted by launch order.
+// Must be called with the lock held.
+func (c *Cloud) sortedInstances() []*Instance {
+	instances := make([]*Instance, 0, len(c.instances))
+	for _, instance := range c.instances {
+		instances = append(instances, instance)
+	}
+	sort.Slice(instances, func(i, j int) bool { return instances[i].launchOrder < instances[j].launchOrder })
+	return instances
+}
+
+// Returns the page of items starting at token, and the token of the next page.
+func page[T any](items []T, token *string, size int) ([]T, *string, error) {
+	start := 0
+	if token != nil {
+		var err error
+		if start, err = strconv.Atoi(*token); err != nil || start > len(items) {
+			return nil, nil, &smithy.GenericAPIError{Code: "InvalidParameterValue", Message: "invalid NextToken", Fault: smithy.FaultClient}
+		}
+	}
+	if size <= 0 || start+size >= len(items) {
+		return items[start:], nil, nil
+	}
+	return items[start : start+size], aws.String(strconv.Itoa(start + size)), nil
+}
+
+// Tells whether the value of a filter matches one of its values.
+func matches(values []string, value string) bool {
+	return slices.Contains(values, value)
+}
+
+func (c *Cloud) DescribeInstances(ctx context.Context, params *ec2.DescribeInstancesInput, optFns ...func(*ec2.Options)) (*ec2.DescribeInstancesOutput, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if err := c.call("EC2", "DescribeInstances"); err != nil {
+		return nil, err
+	}
+
+	var reservations []ec2types.Reservation
+	for _, instance := range c.sortedInstances() {
+		if len(params.InstanceIds) > 0 && !matches(params.InstanceIds, instance.ID) {
+			continue
+		}
+
+		match := true
+		for _, filter := range params.Filters {
+			switch *filter.Name {
+			case "tag:Name":
+				match = match && matches(filter.Values, instance.Name)
+			case "instance-state-name":
+				match = match && matches(filter.Values, string(instance.State))
+			case "subnet-id":
+				match = match && matches(filter.Values, instance.SubnetID)
+			default:
+				return nil, fmt.Errorf("unsupported DescribeInstances filter %s", *filter.Name)
+			}
+		}
+		if !match {
+			continue
+		}
+
+		ec2Instance := ec2types.Instance{
+			InstanceId:       aws.String(instance.ID),
+			PrivateIpAddress: aws.String(instance.PrivateIP),
+			State:            &ec2types.InstanceState{Name: instance.State},
+		}
+		if instance.IPv6 != "" {
+			ec2Instance.Ipv6Address = aws.String(instance.IPv6)
+		}
+		if instance.SubnetID != "" {
+			ec2Instance.SubnetId = aws.String(instance.SubnetID)
+		}
+		reservations = append(reservations, ec2types.Reservation{Instances: []ec2types.Instance{ec2Instance}})
+	}
+
+	reservations, next, err := page(reservations, params.NextToken, c.pageSize)
+	if err != nil {
+		return nil, err
+	}
+	return &ec2.DescribeInstancesOutput{Reservations: reservations, NextToken: next}, nil
+}
+
+func (c *Cloud) DescribeSubnets(ctx context.Context, params *ec2.DescribeSubnetsInput, optFns ...func(*ec2.Options)) (*ec2.DescribeSubnetsOutput, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if err := c.call("EC2", "DescribeSubnets"); err != nil {
+		return nil, err
+	}
+
+	names := make([]string, 0, len(c.subnets))
+	for name := range c.subnets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var subnets []ec2types.Subnet
+	for _, name := range names {
+		match := true
+		for _, filter := range params.Filters {
+			switch *filter.Name {
+			case "tag:Name":
+				match = match && matches(filter.Values, name)
+			default:
+				return nil, fmt.Errorf("unsupported DescribeSubnets filter %s", *filter.Name)
+			}
+		}
+		if match {
+			subnets = append(subnets, ec2types.Subnet{SubnetId: aws.String(c.subnets[name])})
+		}
+	}
+
+	subnets, next, err := page(subnets, params.NextToken, c.pageSize)
+	if err != nil {
+		return nil, err
+	}
+	return &ec2.DescribeSubnetsOutput{Subnets: subnets, NextToken: next}, nil
+}
+
+func (c *Cloud) DescribeAutoScalingGroups(ctx context.Context, params *autoscaling.DescribeAutoScalingGroupsInput, optFns ...func(*autoscaling.Options)) (*autoscaling.DescribeAutoScalingGroupsOutput, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if err := c.call("Auto Scaling", "DescribeAutoScalingGroups"); err != nil {
+		return nil, err
+	}
+
+	// Groups exist as long as they have instances, named with the tag value used by lookables
+	var names []string
+	members := make(map[string][]asgtypes.Instance)
+	for _, instance := range c.sortedInstances() {
+		if instance.Group == "" || instance.Lifecycle == asgtypes.LifecycleStateTerminated {
+			continue
+		}
+		if _, exists := members[instance.Group]; !exists {
+			names = append(names, instance.Group)
+		}
+		members[instance.Group] = append(members[instance.Group], asgtypes.Instance{
+			InstanceId:     aws.String(instance.ID),
+			LifecycleState: instance.Lifecycle,
+			HealthStatus:   aws.String("Healthy"),
+		})
+	}
+	sort.Strings(names)
+
+	var groups []asgtypes.AutoScalingGroup
+	for _, name := range names {
+		match := true
+		for _, filter := range params.Filters {
+			switch *filter.Name {
+			case "tag-value":
+				match = match && matches(filter.Values, name)
+			default:
+				return nil, fmt.Errorf("unsupported DescribeAutoScalingGroups filter %s", *filter.Name)
+			}
+		}
+		if match {
+			groups = append(groups, asgtypes.AutoScalingGroup{
+				AutoScalingGroupName: aws.String(name),
+				Instances:            members[name],
+			})
+		}
+	}
+
+	groups, next, err := page(groups, params.NextToken, c.pageSize)
+	if err != nil {
+		return nil, err
+	}
+	return &autoscaling.DescribeAutoScalingGroupsOutput{AutoScalingGroups: groups, NextToken: next}, nil
+}
@@ -0,0 +1,110 @@
+package awstest
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"strconv"
+	"testing"
+
+	"github.com/AirVantage/overlord/pkg/lookable"
+	asgtypes "github.com/aws/aws-sdk-go-v2/service/autoscaling/types"
+	ec2types "github.com/aws/aws-sdk-go-v2/service/ec2/types"
+	"github.com/aws/smithy-go"
+)
+
+func TestLookup(t *testing.T) {
+
+	cases := []struct {
+		scenario func(c *Cloud)
+		lookable lookable.Lookable
+		expect   []string
+		// Expected number of DescribeInstances calls, ignored when 0
+		calls    int
+		throttle bool
+	}{
+		/* Instances named with a tag, stopped ones excluded */
+		{
+			scenario: func(c *Cloud) {
+				c.Launch(Instance{Name: "web", PrivateIP: "10.0.0.1"})
+				c.Launch(Instance{Name: "web", PrivateIP: "10.0.0.2"})
+				c.Launch(Instance{Name: "db", PrivateIP: "10.0.0.3"})
+				c.Launch(Instance{ID: "i-stopped", Name: "web", PrivateIP: "10.0.0.4"})
+				c.SetState("i-stopped", ec2types.InstanceStateNameStopped)
+			},
+			lookable: lookable.Tag("web"),
+			expect:   []string{"10.0.0.1", "10.0.0.2"},
+		},
+		/* Results spread over several pages */
+		{
+			scenario: func(c *Cloud) {
+				c.SetPageSize(1)
+				c.Launch(Instance{Name: "web", PrivateIP: "10.0.0.1"})
+				c.Launch(Instance{Name: "web", PrivateIP: "10.0.0.2"})
+				c.Launch(Instance{Name: "web", PrivateIP: "10.0.0.3"})
+			},
+			lookable: lookable.Tag("web"),
+			expect:   []string{"10.0.0.1", "10.0.0.2", "10.0.0.3"},
+			calls:    3,
+		},
+		/* Auto Scaling group members, except standby and terminated ones */
+		{
+			scenario: func(c *Cloud) {
+				c.Launch(Instance{Group: "web-asg", PrivateIP: "10.0.0.1"})
+				c.Launch(Instance{ID: "i-standby", Group: "web-asg", PrivateIP: "10.0.0.2"})
+				c.Launch(Instance{ID: "i-terminated", Group: "web-asg", PrivateIP: "10.0.0.3"})
+				c.Launch(Instance{Group: "web-asg", PrivateIP: "10.0.0.4", Lifecycle: asgtypes.LifecycleStatePending})
+				c.Launch(Instance{Group: "db-asg", PrivateIP: "10.0.0.5"})
+				c.SetLifecycle("i-standby", asgtypes.LifecycleStateStandby)
+				c.Terminate("i-terminated")
+			},
+			lookable: lookable.AutoScalingGroup("web-asg"),
+			expect:   []string{"10.0.0.1"},
+		},
+		/* Instances of a subnet, in IPv6 */
+		{
+			scenario: func(c *Cloud) {
+				c.AddSubnet("private-a", "subnet-1")
+				c.AddSubnet("private-b", "subnet-2")
+				c.Launch(Instance{SubnetID: "subnet-1", PrivateIP: "10.0.0.1", IPv6: "fd00::1"})
+				c.Launch(Instance{SubnetID: "subnet-2", PrivateIP: "10.0.1.1", IPv6: "fd00:1::1"})
+			},
+			lookable: lookable.Subnet("private-a"),
+			expect:   []string{"10.0.0.1"},
+		},
+		/* Throttled call */
+		{
+			scenario: func(c *Cloud) {
+				c.Launch(Instance{Name: "web", PrivateIP: "10.0.0.1"})
+				c.Throttle(1)
+			},
+			lookable: lookable.Tag("web"),
+			throttle: true,
+		},
+	}
+
+	for i, tt := range cases {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			cloud := New()
+			tt.scenario(cloud)
+
+			ips, err := tt.lookable.LookupIPs(context.TODO(), cloud.Clients(), false)
+			if tt.throttle {
+				var ae smithy.APIError
+				if !errors.As(err, &ae) || ae.ErrorCode() != "RequestLimitExceeded" {
+					t.Fatalf("expect throttling error, got %v", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("expect no error, got %v", err)
+			}
+			if !slices.Equal(tt.expect, ips) {
+				t.Errorf("expect %v, got %v", tt.expect, ips)
+			}
+			if tt.calls > 0 && cloud.Calls("DescribeInstances") != tt.calls {
+				t.Errorf("expect %v calls, got %v", tt.calls, cloud.Calls("DescribeInstances"))
+			}
+		})
+	}
+}
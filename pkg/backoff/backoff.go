@@ -0,0 +1,28 @@
+package backoff
+
+// Capped exponential delays, spacing out the attempts of a failing operation
+
+import (
+	"math/rand/v2"
+	"time"
+)
+
+// Backoff gives delays doubling from Min up to Max, each one shortened by a random jitter of up to
+// half of it, so that the clients of a same server do not all retry at once.
+type Backoff struct {
+	Min time.Duration
+	Max time.Duration
+	// Delay without jitter of the last call to Next, 0 after Reset
+	delay time.Duration
+}
+
+// Next returns the delay before the next attempt.
+func (b *Backoff) Next() time.Duration {
+	b.delay = min(max(2*b.delay, b.Min), b.Max)
+	return b.delay - rand.N(b.delay/2+1)
+}
+
+// Reset the delays to Min, once an attempt succeeded.
+func (b *Backoff) Reset() {
+	b.delay = 0
+}
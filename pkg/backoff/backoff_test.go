@@ -0,0 +1,39 @@
+package backoff
+
+import (
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestBackoff(t *testing.T) {
+	b := &Backoff{Min: time.Second, Max: 5 * time.Second}
+
+	cases := []struct {
+		reset bool
+		// Delay without jitter
+		expect time.Duration
+	}{
+		/* First delay */
+		{expect: time.Second},
+		/* Doubled */
+		{expect: 2 * time.Second},
+		{expect: 4 * time.Second},
+		/* Capped */
+		{expect: 5 * time.Second},
+		{expect: 5 * time.Second},
+		/* Reset */
+		{reset: true, expect: time.Second},
+	}
+
+	for i, tt := range cases {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			if tt.reset {
+				b.Reset()
+			}
+			if delay := b.Next(); delay > tt.expect || delay < tt.expect/2 {
+				t.Errorf("expect between %v and %v, got %v", tt.expect/2, tt.expect, delay)
+			}
+		})
+	}
+}
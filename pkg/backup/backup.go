@@ -0,0 +1,174 @@
+package backup
+
+// Keep previous versions of generated files to allow rollbacks
+
+import (
+	"errors"
+	"io/fs"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+)
+
+// ErrNoBackup is returned when restoring a file without any saved version.
+var ErrNoBackup = errors.New("no backup available")
+
+// Store keeps the last versions of each managed file in a backup directory.
+// Versions of a file are stored in their own sub-directory, named 1 for the
+// most recent one, 2 for the one before, and so on.
+type Store struct {
+	Dir string
+	FS  FS
+}
+
+// FS is the filesystem holding the managed files and the backup directory.
+type FS interface {
+	ReadFile(name string) ([]byte, error)
+	Stat(name string) (fs.FileInfo, error)
+	MkdirAll(path string, perm fs.FileMode) error
+	WriteFile(name string, data []byte, perm fs.FileMode) error
+	Rename(oldpath, newpath string) error
+	Remove(name string) error
+}
+
+// New returns a pointer to a Store saving versions under dir, on the filesystem of the
+// operating system when fsys is nil.
+func New(dir string, fsys FS) *Store {
+	if fsys == nil {
+		fsys = osFS{}
+	}
+	return &Store{Dir: dir, FS: fsys}
+}
+
+// Save copies the current content of dest as its most recent version, keeping
+// at most keep versions. Nothing is saved if dest does not exist yet.
+func (s *Store) Save(dest string, keep int) error {
+	if keep <= 0 {
+		return nil
+	}
+
+	if _, err := s.FS.Stat(dest); errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+
+	dir := s.path(dest)
+	if err := s.FS.MkdirAll(dir, 0700); err != nil {
+		return err
+	}
+
+	// Drop the oldest version and shift the others
+	if err := s.FS.Remove(filepath.Join(dir, strconv.Itoa(keep))); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	for i := keep - 1; i > 0; i-- {
+		err := s.FS.Rename(filepath.Join(dir, strconv.Itoa(i)), filepath.Join(dir, strconv.Itoa(i+1)))
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+	}
+
+	return s.copyFile(dest, filepath.Join(dir, "1"))
+}
+
+// Restore puts back the most recent version of dest and removes it from the
+// history, so that a subsequent Restore goes one version further back.
+func (s *Store) Restore(dest string) error {
+	dir := s.path(dest)
+
+	if _, err := s.FS.Stat(filepath.Join(dir, "1")); errors.Is(err, os.ErrNotExist) {
+		return ErrNoBackup
+	}
+
+	if err := s.copyFile(filepath.Join(dir, "1"), dest); err != nil {
+		return err
+	}
+	if err := s.FS.Remove(filepath.Join(dir, "1")); err != nil {
+		return err
+	}
+
+	// Shift the remaining versions
+	for i := 2; ; i++ {
+		err := s.FS.Rename(filepath.Join(dir, strconv.Itoa(i)), filepath.Join(dir, strconv.Itoa(i-1)))
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// Versions returns the number of versions currently saved for dest.
+func (s *Store) Versions(dest string) int {
+	dir := s.path(dest)
+
+	n := 0
+	for {
+		if _, err := s.FS.Stat(filepath.Join(dir, strconv.Itoa(n+1))); err != nil {
+			return n
+		}
+		n++
+	}
+}
+
+// path returns the directory storing the versions of dest.
+func (s *Store) path(dest string) string {
+	abs, err := filepath.Abs(dest)
+	if err != nil {
+		abs = filepath.Clean(dest)
+	}
+	return filepath.Join(s.Dir, url.PathEscape(abs))
+}
+
+// copyFile writes the content of src into dst, created with the file mode of src.
+// The destination is replaced atomically through a temporary file.
+func (s *Store) copyFile(src, dst string) error {
+	info, err := s.FS.Stat(src)
+	if err != nil {
+		return err
+	}
+	content, err := s.FS.ReadFile(src)
+	if err != nil {
+		return err
+	}
+
+	// A temporary file left by a previous failure would keep its own mode
+	tmp := filepath.Join(filepath.Dir(dst), "."+filepath.Base(dst)+".tmp")
+	s.FS.Remove(tmp)
+	if err := s.FS.WriteFile(tmp, content, info.Mode().Perm()); err != nil {
+		return err
+	}
+	if err := s.FS.Rename(tmp, dst); err != nil {
+		s.FS.Remove(tmp)
+		return err
+	}
+	return nil
+}
+
+// osFS is the filesystem of the operating system.
+type osFS struct{}
+
+func (osFS) ReadFile(name string) ([]byte, error) {
+	return os.ReadFile(name)
+}
+
+func (osFS) Stat(name string) (fs.FileInfo, error) {
+	return os.Stat(name)
+}
+
+func (osFS) MkdirAll(path string, perm fs.FileMode) error {
+	return os.MkdirAll(path, perm)
+}
+
+func (osFS) WriteFile(name string, data []byte, perm fs.FileMode) error {
+	return os.WriteFile(name, data, perm)
+}
+
+func (osFS) Rename(oldpath, newpath string) error {
+	return os.Rename(oldpath, newpath)
+}
+
+func (osFS) Remove(name string) error {
+	return os.Remove(name)
+}
@@ -0,0 +1,92 @@
+package backup
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+)
+
+func TestSaveRestore(t *testing.T) {
+
+	cases := []struct {
+		versions []string
+		keep     int
+		restores int
+		expect   string
+		err      error
+	}{
+		/* No previous version */
+		{
+			versions: []string{"v1"},
+			keep:     3,
+			restores: 1,
+			expect:   "v1",
+			err:      ErrNoBackup,
+		},
+		/* Restore previous version */
+		{
+			versions: []string{"v1", "v2"},
+			keep:     3,
+			restores: 1,
+			expect:   "v1",
+		},
+		/* Restore two versions back */
+		{
+			versions: []string{"v1", "v2", "v3"},
+			keep:     3,
+			restores: 2,
+			expect:   "v1",
+		},
+		/* Oldest versions are dropped */
+		{
+			versions: []string{"v1", "v2", "v3", "v4"},
+			keep:     2,
+			restores: 3,
+			expect:   "v2",
+			err:      ErrNoBackup,
+		},
+		/* Backups disabled */
+		{
+			versions: []string{"v1", "v2"},
+			keep:     0,
+			restores: 1,
+			expect:   "v2",
+			err:      ErrNoBackup,
+		},
+	}
+
+	for i, tt := range cases {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			tmp := t.TempDir()
+			dest := filepath.Join(tmp, "dest.conf")
+			store := New(filepath.Join(tmp, "backups"), nil)
+
+			for _, version := range tt.versions {
+				if err := store.Save(dest, tt.keep); err != nil {
+					t.Fatalf("expect no error, got %v", err)
+				}
+				if err := os.WriteFile(dest, []byte(version), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			var err error
+			for r := 0; r < tt.restores && err == nil; r++ {
+				err = store.Restore(dest)
+			}
+			if !errors.Is(err, tt.err) {
+				t.Errorf("expect error %v, got %v", tt.err, err)
+			}
+
+			content, err := os.ReadFile(dest)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(content) != tt.expect {
+				t.Errorf("expect %v, got %v", tt.expect, string(content))
+			}
+		})
+	}
+}
@@ -0,0 +1,752 @@
+package engine
+
+// Reconcile generated files and managed processes with the instances of lookables
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"html/template"
+	"io"
+	"io/fs"
+	"log/slog"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/AirVantage/overlord/pkg/backoff"
+	"github.com/AirVantage/overlord/pkg/backup"
+	"github.com/AirVantage/overlord/pkg/changes"
+	"github.com/AirVantage/overlord/pkg/inventory"
+	"github.com/AirVantage/overlord/pkg/lookable"
+	"github.com/AirVantage/overlord/pkg/resource"
+	"github.com/AirVantage/overlord/pkg/set"
+	"github.com/AirVantage/overlord/pkg/state"
+	"github.com/BurntSushi/toml"
+)
+
+// Sub-directories of the configuration directory
+const (
+	ResourcesDir = "resources"
+	TemplatesDir = "templates"
+)
+
+// Delays before watching again a lookable whose watch failed, doubled on each consecutive failure
+const (
+	watchRetry    = 10 * time.Second
+	watchRetryMax = 5 * time.Minute
+)
+
+var errNoClients = errors.New("no lookable clients configured")
+
+// Config of a Reconciler. FS, Runner and Clock default to the ones of the operating system.
+// FS holds the configuration directory, dest files and backups, Runner runs the reload commands,
+// signals and unit jobs, and Clock times reloads, verify probe retries and iterations. Lookups go
+// through Clients, except for the lookables reaching their source directly: files, DNS, Consul,
+// Kubernetes, Docker and plugins. Verify probes reach the network and run commands directly too.
+type Config struct {
+	// Configuration directory, holding the resources and templates directories
+	Dir string
+	// Directory keeping previous versions of generated files, on FS.
+	// Required by resources keeping backups.
+	BackupDir string
+	// Interval between iterations of Run, required by Run
+	Interval time.Duration
+	// Look for IPv6 addresses instead of IPv4
+	IPv6 bool
+	// Clients of the lookables, required by the lookups of RunOnce, Plan, Run and Render
+	Clients *lookable.Clients
+	FS      FS
+	Runner  Runner
+	Clock   Clock
+	// Called synchronously for each event, optional
+	OnEvent func(Event)
+}
+
+// EventType tells what an Event is about.
+type EventType int
+
+const (
+	// An iteration started
+	IterationStarted EventType = iota
+	// The IPs of a lookable changed: Lookable and Changes are set
+	LookableChanged
+	// The template of a resource is new or was modified: Resource is set
+	TemplateChanged
+	// The dest file of a resource was generated: Resource is set
+	ResourceRendered
+	// A group of resources was reloaded: Reload is set
+	ResourcesReloaded
+	// An iteration completed successfully: Result is set
+	IterationDone
+)
+
+// Event reports the progress of an iteration.
+type Event struct {
+	Type     EventType
+	Lookable string
+	Changes  *changes.Changes[string]
+	Resource *resource.Resource
+	Reload   *Reload
+	Result   *Result
+}
+
+// Reload is the outcome of the reload of resources sharing the same reload command.
+type Reload struct {
+	Resources []*resource.Resource
+	Command   string
+	// Merged IP changes of the resources
+	Changes *changes.Changes[string]
+	// Outcome of the reload, nil when planned
+	Status *state.Reload
+}
+
+// Result of an iteration.
+type Result struct {
+	State *state.State
+	// IP changes of each lookable whose instances changed
+	Changes map[string]*changes.Changes[string]
+	// Resources whose dest file was generated
+	Rendered []*resource.Resource
+	// Reloads in the order they were run
+	Reloads []*Reload
+	// Dest files which would change, set by Plan only
+	Diffs []*Diff
+	// Lookables watched by the resources, sorted by name
+	Lookables []lookable.Lookable
+}
+
+// Diff is the content of a dest file and the one it would be replaced with.
+type Diff struct {
+	Resource *resource.Resource
+	Current  string
+	Rendered string
+}
+
+// Reconciler looks up the lookables watched by the resources of a configuration directory,
+// generates their dest files and reloads them when their instances change.
+// RunOnce and Run must not be called concurrently.
+type Reconciler struct {
+	config  Config
+	backups *backup.Store
+	state   *state.State
+	trigger chan struct{}
+	// Wakes Run up when a watched lookable changed
+	wake chan struct{}
+}
+
+// New returns a pointer to a Reconciler starting from an empty state.
+func New(config Config) *Reconciler {
+	if config.FS == nil {
+		config.FS = OSFS{}
+	}
+	if config.Runner == nil {
+		config.Runner = ExecRunner{}
+	}
+	if config.Clock == nil {
+		config.Clock = SystemClock{}
+	}
+
+	return &Reconciler{
+		config:  config,
+		backups: backup.New(config.BackupDir, config.FS),
+		state:   state.New(),
+		trigger: make(chan struct{}, 1),
+		wake:    make(chan struct{}, 1),
+	}
+}
+
+// State returns the state of the last successful iteration.
+func (r *Reconciler) State() *state.State {
+	return r.state
+}
+
+// SetState replaces the state compared to by the next iteration, e.g. to plan from a saved state.
+func (r *Reconciler) SetState(s *state.State) {
+	r.state = s
+}
+
+// Trigger wakes Run up for an immediate iteration. When an iteration is running,
+// it reloads instead the resources of the lookables it has not looked up yet.
+func (r *Reconciler) Trigger() {
+	select {
+	case r.trigger <- struct{}{}:
+	default:
+	}
+}
+
+// Channel of triggers, nil for dry runs so that they are kept for the next iteration.
+func (r *Reconciler) triggered(dryRun bool) <-chan struct{} {
+	if dryRun {
+		return nil
+	}
+	return r.trigger
+}
+
+func (r *Reconciler) emit(event Event) {
+	if r.config.OnEvent != nil {
+		r.config.OnEvent(event)
+	}
+}
+
+// Run iterations every interval until ctx is done or an iteration fails. Lookables which are
+// Watchers are watched in the background, their changes wake Run up for an immediate iteration.
+func (r *Reconciler) Run(ctx context.Context) error {
+	if r.config.Interval <= 0 {
+		return fmt.Errorf("invalid interval %s", r.config.Interval)
+	}
+
+	watches := make(map[lookable.Lookable]context.CancelFunc)
+	defer func() {
+		for _, cancel := range watches {
+			cancel()
+		}
+	}()
+
+	for {
+		result, err := r.RunOnce(ctx)
+		if err != nil {
+			return err
+		}
+		r.updateWatches(ctx, watches, result.Lookables)
+
+		// Sleep for the configured interval, but wake up immediately when triggered or when a watched lookable changed
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-r.config.Clock.After(r.config.Interval):
+		case <-r.trigger:
+			slog.Info("Iteration triggered, interrupting sleep")
+		case <-r.wake:
+			slog.Info("Watched lookable changed, interrupting sleep")
+		}
+	}
+}
+
+// Start watching the Watchers of lookables which are not watched yet, and stop watching
+// the ones which are no longer looked up.
+func (r *Reconciler) updateWatches(ctx context.Context, watches map[lookable.Lookable]context.CancelFunc, lookables []lookable.Lookable) {
+	watched := set.New[lookable.Lookable]()
+	for _, l := range lookables {
+		w, ok := l.(lookable.Watcher)
+		if !ok {
+			continue
+		}
+		watched.Add(l)
+		if _, exists := watches[l]; !exists {
+			watchCtx, cancel := context.WithCancel(ctx)
+			watches[l] = cancel
+			go r.watch(watchCtx, w)
+		}
+	}
+
+	for l, cancel := range watches {
+		if !watched.Has(l) {
+			cancel()
+			delete(watches, l)
+		}
+	}
+}
+
+// Watch w until ctx is done, watching again after a failure with a backoff from watchRetry to watchRetryMax.
+func (r *Reconciler) watch(ctx context.Context, w lookable.Watcher) {
+	slog.Debug("Watching lookable", "lookable", w)
+	changed := func() {
+		select {
+		case r.wake <- struct{}{}:
+		default:
+		}
+	}
+
+	retry := &backoff.Backoff{Min: watchRetry, Max: watchRetryMax}
+	for {
+		start := r.config.Clock.Now()
+		err := w.Watch(ctx, r.config.Clients, changed)
+		if ctx.Err() != nil {
+			return
+		}
+		// A watch which lasted did not fail for the lack of its source, like the connection closed by a server
+		if r.config.Clock.Now().Sub(start) >= watchRetryMax {
+			retry.Reset()
+		}
+		delay := retry.Next()
+		slog.Warn("Watch failed, retrying", "lookable", w, "error", err, "retry", delay)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-r.config.Clock.After(delay):
+		}
+	}
+}
+
+// RunOnce runs a single iteration: lookup, render and reload.
+// On failure, the state is kept unchanged so that the next iteration detects the same changes.
+func (r *Reconciler) RunOnce(ctx context.Context) (*Result, error) {
+	return r.iterate(ctx, false)
+}
+
+// Plan runs the lookups and renders of an iteration without writing, reloading nor emitting
+// anything. The Result lists the dest files which would change and the reloads which would run,
+// without status. The state is kept unchanged.
+func (r *Reconciler) Plan(ctx context.Context) (*Result, error) {
+	return r.iterate(ctx, true)
+}
+
+func (r *Reconciler) iterate(ctx context.Context, dryRun bool) (*Result, error) {
+	var (
+		prevState         *state.State                                    = r.state
+		resources         map[lookable.Lookable][]*resource.Resource      = make(map[lookable.Lookable][]*resource.Resource)
+		resourcesToUpdate map[*resource.Resource]*changes.Changes[string] = make(map[*resource.Resource]*changes.Changes[string])
+		groupChanges      map[string]*changes.Changes[string]             = make(map[string]*changes.Changes[string])
+		fullReload        map[*resource.Resource]bool                     = make(map[*resource.Resource]bool)
+		newState          *state.State                                    = state.New()
+		result            *Result                                         = &Result{State: newState, Changes: groupChanges}
+	)
+
+	emit := func(event Event) {
+		if !dryRun {
+			r.emit(event)
+		}
+	}
+
+	if r.config.Clients == nil {
+		return nil, errNoClients
+	}
+
+	slog.Debug("Start iteration")
+	emit(Event{Type: IterationStarted})
+
+	// load resources definition files
+	resourcesFiles, err := r.resourceFiles()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, name := range resourcesFiles {
+		rc, err := r.loadResource(name)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", name, err)
+		}
+
+		// Resources without template only declare lookables, to serve them through xDS
+		if rc.HasTemplate() {
+			newState.Templates[rc.Src] = rc
+		}
+
+		// Store each resource in a reverse map, listing resource linked to each lookable to easily match updates need per lookable changes
+		for _, l := range rc.Lookables() {
+			resources[l] = append(resources[l], rc)
+		}
+	}
+
+	for l := range resources {
+		result.Lookables = append(result.Lookables, l)
+	}
+	sort.Slice(result.Lookables, func(i, j int) bool { return result.Lookables[i].String() < result.Lookables[j].String() })
+	if err := lookable.CheckNames(result.Lookables); err != nil {
+		return nil, err
+	}
+	if err := resource.CheckEnvSuffixes(result.Lookables); err != nil {
+		return nil, err
+	}
+
+	// keep track of previous reloads outcome
+	for file := range newState.Templates {
+		if status, exists := prevState.Reloads[file]; exists {
+			newState.Reloads[file] = status
+		}
+		if hash, exists := prevState.RolledBack[file]; exists {
+			newState.RolledBack[file] = hash
+		}
+	}
+
+	// find group ips to update
+	slog.Debug("Find Resources to update")
+	for g, resourcesset := range resources {
+
+		// Check for a triggered reload (non-blocking), left for the next iteration by a dry run
+		select {
+		case <-r.triggered(dryRun):
+			slog.Info("Iteration triggered while running, forcing configuration reload")
+			// Force update of all resources by marking them as changed
+			for _, resource := range resourcesset {
+				fullReload[resource] = true
+				if _, exists := resourcesToUpdate[resource]; !exists {
+					resourcesToUpdate[resource] = changes.New[string]()
+				}
+			}
+		default:
+			// Not triggered, continue normal processing
+		}
+
+		group := g.String()
+		members, err := lookable.Lookup(ctx, g, r.config.Clients, r.config.IPv6)
+
+		// if some AWS API calls failed during the IPs lookup, stop here and exit
+		// it will keep the dest file unmodified and won't execute the reload command.
+		if err != nil {
+			return nil, err
+		}
+
+		ips := lookable.IPs(members)
+		newState.Ipsets[group] = set.New[string]()
+		changes := changes.New[string]()
+		changed := false
+
+		prevIPs, exists := prevState.Ipsets[group]
+		if !exists {
+			prevIPs = set.New[string]()
+		}
+
+		for _, ip := range ips {
+			newState.Ipsets[group].Add(ip)
+			if !prevIPs.Has(ip) {
+				changed = true
+				changes.Add(ip)
+				slog.Info("Additional IP detected", "group", group, "IP", ip)
+			}
+		}
+
+		for _, oldIP := range prevIPs.ToSlice() {
+			if !newState.Ipsets[group].Has(oldIP) {
+				changed = true
+				changes.Remove(oldIP)
+				slog.Info("Deprecated IP detected", "group", group, "IP", oldIP)
+			}
+		}
+
+		// Details of the members other than their IP are only kept for the templates
+		if _, ok := g.(lookable.MemberLookable); ok {
+			newState.Members[group] = members
+			if prevMembers, exists := prevState.Members[group]; exists && !changed && !lookable.EqualMembers(prevMembers, members) {
+				slog.Info("Member details changed - marking resource for update", "group", group)
+				for _, resource := range resourcesset {
+					fullReload[resource] = true
+					if _, exists := resourcesToUpdate[resource]; !exists {
+						resourcesToUpdate[resource] = changes
+					}
+				}
+			}
+		}
+
+		if changed {
+			groupChanges[group] = changes
+			emit(Event{Type: LookableChanged, Lookable: group, Changes: changes})
+			for _, resource := range resourcesset {
+				slog.Info("IP changes detected - marking resource for update",
+					"group", group,
+					"src", resource.Src,
+					"dest", resource.Dest)
+
+				// Merge Changes to store IP changes across differents aws resources:
+				if prevChanges, exists := resourcesToUpdate[resource]; exists {
+					resourcesToUpdate[resource] = prevChanges.Merge(changes)
+				} else {
+					resourcesToUpdate[resource] = changes
+				}
+			}
+		}
+	}
+
+	// If new resource or template file changed since last run:
+	for file, rc := range newState.Templates {
+		if prevrc, exists := prevState.Templates[file]; !exists || rc.SrcFSInfo.ModTime().Sub(prevrc.SrcFSInfo.ModTime()) > 0 {
+			slog.Info("Template changed", "template", file, "mod time", rc.SrcFSInfo.ModTime())
+			emit(Event{Type: TemplateChanged, Resource: rc})
+			fullReload[rc] = true
+			if _, exists := resourcesToUpdate[rc]; !exists {
+				resourcesToUpdate[rc] = changes.New[string]()
+			}
+		}
+	}
+
+	// Convert set to sorted array for use with text/template
+	ips := newState.IPs()
+	members := newState.Members
+
+	// generate resources, in a stable order
+	slog.Debug("Update resources and restart processes")
+	var toRender []*resource.Resource
+	for resource := range resourcesToUpdate {
+		if resource.HasTemplate() {
+			toRender = append(toRender, resource)
+		}
+	}
+	sort.Slice(toRender, func(i, j int) bool { return toRender[i].Src < toRender[j].Src })
+
+	hashes := make(map[*resource.Resource]string)
+	for _, resource := range toRender {
+		content, err := r.render(resource, ips, members)
+		if err != nil {
+			return nil, err
+		}
+
+		// A content rolled back before would fail again, it is left until the template or the IPs change
+		hash := contentHash(content)
+		if prevState.RolledBack[resource.Src] == hash {
+			slog.Warn("Rendered resource was rolled back before, skipping it",
+				"resource_template", resource.Src,
+				"dest", resource.Dest)
+			delete(resourcesToUpdate, resource)
+			continue
+		}
+		delete(newState.RolledBack, resource.Src)
+		hashes[resource] = hash
+		result.Rendered = append(result.Rendered, resource)
+
+		if dryRun {
+			current, _ := r.config.FS.ReadFile(resource.Dest)
+			if !bytes.Equal(current, content) {
+				result.Diffs = append(result.Diffs, &Diff{Resource: resource, Current: string(current), Rendered: string(content)})
+			}
+			continue
+		}
+
+		if err := r.write(resource, content); err != nil {
+			return nil, err
+		}
+		slog.Info("Updating managed resource", "resource", resource)
+		emit(Event{Type: ResourceRendered, Resource: resource})
+	}
+
+	// reload processes once per group, after all their resources were generated
+	for _, group := range groupReloads(resourcesToUpdate, fullReload, groupChanges, ips) {
+		reload := &Reload{
+			Resources: group.resources,
+			Command:   group.leader().Command(),
+			Changes:   group.changes,
+		}
+		result.Reloads = append(result.Reloads, reload)
+		if dryRun {
+			continue
+		}
+
+		reload.Status = r.apply(ctx, group)
+		for _, resource := range group.resources {
+			newState.Reloads[resource.Src] = reload.Status
+
+			// Remember the content of a rolled back resource so that it is not applied again
+			if hash, exists := hashes[resource]; exists && reload.Status.RolledBack && resource.Backups > 0 {
+				newState.RolledBack[resource.Src] = hash
+			}
+		}
+		emit(Event{Type: ResourcesReloaded, Reload: reload})
+	}
+
+	if dryRun {
+		return result, nil
+	}
+
+	slog.Debug("Iteration done", "state", newState)
+	r.state = newState
+	emit(Event{Type: IterationDone, Result: result})
+	return result, nil
+}
+
+// Names of the resources definition files.
+func (r *Reconciler) resourceFiles() ([]string, error) {
+	entries, err := r.config.FS.ReadDir(filepath.Join(r.config.Dir, ResourcesDir))
+	if err != nil {
+		return nil, err
+	}
+
+	var names []string
+	for _, entry := range entries {
+		if filepath.Ext(entry.Name()) != ".toml" || entry.IsDir() {
+			continue
+		}
+		names = append(names, entry.Name())
+	}
+	return names, nil
+}
+
+// Load and validate the resource defined in a file of the resources directory.
+func (r *Reconciler) loadResource(name string) (*resource.Resource, error) {
+	content, err := r.config.FS.ReadFile(filepath.Join(r.config.Dir, ResourcesDir, name))
+	if err != nil {
+		return nil, err
+	}
+
+	var rc *resource.ResourceConfig
+	if _, err := toml.Decode(string(content), &rc); err != nil {
+		return nil, err
+	}
+	if err := rc.Resource.Validate(); err != nil {
+		return nil, err
+	}
+
+	slog.Debug("Reading resource configuration",
+		"filename", name,
+		"configuration", rc)
+
+	if rc.Resource.HasTemplate() {
+		rc.Resource.SrcFSInfo, err = r.config.FS.Stat(filepath.Join(r.config.Dir, TemplatesDir, rc.Resource.Src))
+		if err != nil {
+			return nil, err
+		}
+	}
+	return &rc.Resource, nil
+}
+
+// Functions available to templates, bound to the lookables by render.
+var templateFuncs = template.FuncMap{
+	"members": func(name string) []lookable.Member { return nil },
+}
+
+// Parse the template of a resource.
+func (r *Reconciler) parseTemplate(resource *resource.Resource) (*template.Template, error) {
+	content, err := r.config.FS.ReadFile(filepath.Join(r.config.Dir, TemplatesDir, resource.Src))
+	if err != nil {
+		return nil, err
+	}
+	return template.New(filepath.Base(resource.Src)).Funcs(templateFuncs).Parse(string(content))
+}
+
+// Validate loads all the resources and parses their templates, without looking anything up.
+// Returns the errors of all the invalid files.
+func (r *Reconciler) Validate() error {
+	names, err := r.resourceFiles()
+	if err != nil {
+		return err
+	}
+
+	var (
+		errs      []error
+		lookables []lookable.Lookable
+	)
+	for _, name := range names {
+		rc, err := r.loadResource(name)
+		if err == nil && rc.HasTemplate() {
+			_, err = r.parseTemplate(rc)
+		}
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", name, err))
+			continue
+		}
+		lookables = append(lookables, rc.Lookables()...)
+	}
+	// Resources may watch the same lookables, but not different ones of the same name or environment variables
+	if err := lookable.CheckNames(lookables); err != nil {
+		errs = append(errs, err)
+	} else if err := resource.CheckEnvSuffixes(lookables); err != nil {
+		errs = append(errs, err)
+	}
+	return errors.Join(errs...)
+}
+
+// Render writes to w the template of the resource defined in the file name, with or without
+// its .toml extension, using the current members of the lookables it watches. Nothing else is touched.
+func (r *Reconciler) Render(ctx context.Context, name string, w io.Writer) error {
+	if r.config.Clients == nil {
+		return errNoClients
+	}
+	rc, err := r.templateResource(name)
+	if err != nil {
+		return err
+	}
+
+	ips := make(map[string][]string)
+	members := make(map[string][]lookable.Member)
+	for _, l := range rc.Lookables() {
+		lookableMembers, err := lookable.Lookup(ctx, l, r.config.Clients, r.config.IPv6)
+		if err != nil {
+			return err
+		}
+		ips[l.String()] = lookable.IPs(lookableMembers)
+		members[l.String()] = lookableMembers
+	}
+
+	content, err := r.render(rc, ips, members)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(content)
+	return err
+}
+
+// RenderFixture writes to w the template of the resource defined in the file name, with or
+// without its .toml extension, using the members of a fixture instead of looking them up.
+func (r *Reconciler) RenderFixture(name string, members inventory.Inventory, w io.Writer) error {
+	rc, err := r.templateResource(name)
+	if err != nil {
+		return err
+	}
+
+	content, err := r.render(rc, members.IPs(), members)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(content)
+	return err
+}
+
+// Load the resource defined in the file name, with or without its .toml extension, which must have a template.
+func (r *Reconciler) templateResource(name string) (*resource.Resource, error) {
+	if filepath.Ext(name) != ".toml" {
+		name += ".toml"
+	}
+	rc, err := r.loadResource(name)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", name, err)
+	}
+	if !rc.HasTemplate() {
+		return nil, fmt.Errorf("%s: no template to render", name)
+	}
+	return rc, nil
+}
+
+// Render the template of a resource with the IPs of each lookable. The members function of
+// the template returns the members of a lookable, with only their IP when not in members.
+func (r *Reconciler) render(resource *resource.Resource, ips map[string][]string, members map[string][]lookable.Member) ([]byte, error) {
+	tmpl, err := r.parseTemplate(resource)
+	if err != nil {
+		return nil, err
+	}
+
+	tmpl.Funcs(template.FuncMap{"members": func(name string) []lookable.Member {
+		if lookableMembers, exists := members[name]; exists {
+			return lookableMembers
+		}
+		return lookable.Members(ips[name])
+	}})
+
+	var output bytes.Buffer
+	if err := tmpl.Execute(&output, ips); err != nil {
+		return nil, err
+	}
+	return output.Bytes(), nil
+}
+
+// Hash of the rendered content of a dest file.
+func contentHash(content []byte) string {
+	hash := sha256.Sum256(content)
+	return hex.EncodeToString(hash[:])
+}
+
+// Write the dest file of a resource, keeping the current one as backup.
+func (r *Reconciler) write(resource *resource.Resource, content []byte) error {
+	if err := r.config.FS.MkdirAll(filepath.Dir(resource.Dest), 0777); err != nil {
+		return err
+	}
+	// keep the current dest file to be able to rollback on reload failure
+	if resource.Backups > 0 {
+		if r.config.BackupDir == "" {
+			return fmt.Errorf("%s: no backup directory configured", resource.Dest)
+		}
+		current, err := r.config.FS.ReadFile(resource.Dest)
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+		// An identical version, or one which was rolled back, would push the known-good ones out
+		if err == nil && !bytes.Equal(current, content) && r.state.RolledBack[resource.Src] != contentHash(current) {
+			if err := r.backups.Save(resource.Dest, resource.Backups); err != nil {
+				return err
+			}
+		}
+	}
+	return r.config.FS.WriteFile(resource.Dest, content, 0666)
+}
@@ -0,0 +1,1036 @@
+package engine_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/AirVantage/overlord/pkg/awstest"
+	"github.com/AirVantage/overlord/pkg/engine"
+	"github.com/AirVantage/overlord/pkg/engine/enginetest"
+	"github.com/AirVantage/overlord/pkg/haproxy/haproxytest"
+	"github.com/AirVantage/overlord/pkg/inventory"
+	asgtypes "github.com/aws/aws-sdk-go-v2/service/autoscaling/types"
+)
+
+var start = time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// Fake AWS, filesystem, runner and clock of a Reconciler watching an Auto Scaling group and a tag.
+type fixture struct {
+	cloud      *awstest.Cloud
+	fs         *enginetest.FS
+	runner     *enginetest.Runner
+	clock      *enginetest.Clock
+	events     chan engine.EventType
+	reconciler *engine.Reconciler
+}
+
+func newFixture() *fixture {
+	f := &fixture{
+		cloud:  awstest.New(),
+		fs:     enginetest.NewFS(),
+		runner: &enginetest.Runner{},
+		clock:  enginetest.NewClock(start),
+		events: make(chan engine.EventType, 100),
+	}
+
+	f.fs.Add("/etc/overlord/resources/web.toml", `
+[template]
+src = "web.tmpl"
+dest = "/run/web.conf"
+groups = ["web-asg"]
+tags = ["db"]
+reload_cmd = "reload web"
+`, start)
+	f.fs.Add("/etc/overlord/templates/web.tmpl", `{{range index . "web-asg"}}server {{.}}
+{{end}}{{range index . "db"}}db {{.}}
+{{end}}`, start)
+
+	f.reconciler = engine.New(engine.Config{
+		Dir:       "/etc/overlord",
+		BackupDir: "/var/lib/overlord/backups",
+		Interval:  time.Minute,
+		Clients:   f.cloud.Clients(),
+		FS:        f.fs,
+		Runner:    f.runner,
+		Clock:     f.clock,
+		OnEvent:   func(event engine.Event) { f.events <- event.Type },
+	})
+	return f
+}
+
+// Events received since the last call.
+func (f *fixture) receivedEvents() []engine.EventType {
+	var events []engine.EventType
+	for {
+		select {
+		case event := <-f.events:
+			events = append(events, event)
+		default:
+			return events
+		}
+	}
+}
+
+func TestRunOnce(t *testing.T) {
+	f := newFixture()
+
+	cases := []struct {
+		scenario func(c *awstest.Cloud, r *enginetest.Runner)
+		err      bool
+		content  string
+		// Expected environment of the reload command, no reload expected when nil
+		env    map[string]string
+		failed bool
+		events []engine.EventType
+	}{
+		/* First iteration renders and reloads the new template */
+		{
+			scenario: func(c *awstest.Cloud, r *enginetest.Runner) {
+				c.Launch(awstest.Instance{ID: "i-1", Group: "web-asg", PrivateIP: "10.0.0.1"})
+				c.Launch(awstest.Instance{ID: "i-2", Name: "db", PrivateIP: "10.0.1.1"})
+			},
+			content: "server 10.0.0.1\ndb 10.0.1.1\n",
+			env: map[string]string{
+				"IP_ADDED":          "10.0.0.1 10.0.1.1",
+				"IP_REMOVED":        "",
+				"IP_ADDED_WEB_ASG":  "10.0.0.1",
+				"IP_CURRENT_DB":     "10.0.1.1",
+				"OVERLORD_RESOURCE": "web.tmpl",
+				"OVERLORD_DEST":     "/run/web.conf",
+			},
+			events: []engine.EventType{engine.IterationStarted, engine.LookableChanged, engine.LookableChanged, engine.TemplateChanged, engine.ResourceRendered, engine.ResourcesReloaded, engine.IterationDone},
+		},
+		/* Nothing changed */
+		{
+			scenario: func(c *awstest.Cloud, r *enginetest.Runner) {},
+			content:  "server 10.0.0.1\ndb 10.0.1.1\n",
+			events:   []engine.EventType{engine.IterationStarted, engine.IterationDone},
+		},
+		/* Scale out */
+		{
+			scenario: func(c *awstest.Cloud, r *enginetest.Runner) {
+				c.Launch(awstest.Instance{ID: "i-3", Group: "web-asg", PrivateIP: "10.0.0.2"})
+			},
+			content: "server 10.0.0.1\nserver 10.0.0.2\ndb 10.0.1.1\n",
+			env: map[string]string{
+				"IP_ADDED":           "10.0.0.2",
+				"IP_ADDED_WEB_ASG":   "10.0.0.2",
+				"IP_CURRENT_WEB_ASG": "10.0.0.1 10.0.0.2",
+				"IP_ADDED_DB":        "",
+				"IP_CURRENT_DB":      "10.0.1.1",
+			},
+			events: []engine.EventType{engine.IterationStarted, engine.LookableChanged, engine.ResourceRendered, engine.ResourcesReloaded, engine.IterationDone},
+		},
+		/* Instance moved to Standby */
+		{
+			scenario: func(c *awstest.Cloud, r *enginetest.Runner) {
+				c.SetLifecycle("i-1", asgtypes.LifecycleStateStandby)
+			},
+			content: "server 10.0.0.2\ndb 10.0.1.1\n",
+			env: map[string]string{
+				"IP_ADDED":           "",
+				"IP_REMOVED":         "10.0.0.1",
+				"IP_REMOVED_WEB_ASG": "10.0.0.1",
+			},
+		},
+		/* Instance back in service while another one terminates */
+		{
+			scenario: func(c *awstest.Cloud, r *enginetest.Runner) {
+				c.SetLifecycle("i-1", asgtypes.LifecycleStateInService)
+				c.Terminate("i-3")
+			},
+			content: "server 10.0.0.1\ndb 10.0.1.1\n",
+			env: map[string]string{
+				"IP_ADDED":   "10.0.0.1",
+				"IP_REMOVED": "10.0.0.2",
+			},
+		},
+		/* Throttled lookup fails the iteration without touching the dest file */
+		{
+			scenario: func(c *awstest.Cloud, r *enginetest.Runner) {
+				c.Launch(awstest.Instance{ID: "i-4", Name: "db", PrivateIP: "10.0.1.2"})
+				c.Throttle(1)
+			},
+			err:     true,
+			content: "server 10.0.0.1\ndb 10.0.1.1\n",
+		},
+		/* Next iteration detects the changes missed by the failed one */
+		{
+			scenario: func(c *awstest.Cloud, r *enginetest.Runner) {},
+			content:  "server 10.0.0.1\ndb 10.0.1.1\ndb 10.0.1.2\n",
+			env: map[string]string{
+				"IP_ADDED":    "10.0.1.2",
+				"IP_ADDED_DB": "10.0.1.2",
+			},
+		},
+		/* Failed reload command */
+		{
+			scenario: func(c *awstest.Cloud, r *enginetest.Runner) {
+				c.Terminate("i-4")
+				r.FailWith(1)
+			},
+			content: "server 10.0.0.1\ndb 10.0.1.1\n",
+			env: map[string]string{
+				"IP_REMOVED": "10.0.1.2",
+			},
+			failed: true,
+		},
+		/* Results over several pages are all taken into account */
+		{
+			scenario: func(c *awstest.Cloud, r *enginetest.Runner) {
+				c.SetPageSize(1)
+				c.Launch(awstest.Instance{ID: "i-5", Group: "web-asg", PrivateIP: "10.0.0.3"})
+			},
+			content: "server 10.0.0.1\nserver 10.0.0.3\ndb 10.0.1.1\n",
+			env: map[string]string{
+				"IP_ADDED":           "10.0.0.3",
+				"IP_CURRENT_WEB_ASG": "10.0.0.1 10.0.0.3",
+			},
+		},
+	}
+
+	for i, tt := range cases {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			tt.scenario(f.cloud, f.runner)
+
+			result, err := f.reconciler.RunOnce(context.Background())
+			if (err != nil) != tt.err {
+				t.Fatalf("expect error %v, got %v", tt.err, err)
+			}
+
+			if content := f.fs.Content("/run/web.conf"); content != tt.content {
+				t.Errorf("expect %q, got %q", tt.content, content)
+			}
+
+			events := f.receivedEvents()
+			if tt.events != nil && !slices.Equal(events, tt.events) {
+				t.Errorf("expect events %v, got %v", tt.events, events)
+			}
+
+			calls := f.runner.Calls()
+			if tt.env == nil {
+				if len(calls) != 0 {
+					t.Errorf("expect no reload, got %v", calls)
+				}
+				return
+			}
+			if len(calls) != 1 || len(result.Reloads) != 1 {
+				t.Fatalf("expect a single reload, got %v", calls)
+			}
+
+			if calls[0].Command.Script != "reload web" {
+				t.Errorf("expect reload web, got %v", calls[0].Command.Script)
+			}
+			for name, expect := range tt.env {
+				if value := calls[0].Getenv(name); value != expect {
+					t.Errorf("expect %s=%v, got %v", name, expect, value)
+				}
+			}
+			if !strings.Contains(calls[0].Changes, `"web-asg"`) {
+				t.Errorf("expect changes file with web-asg group, got %v", calls[0].Changes)
+			}
+
+			status := result.Reloads[0].Status
+			if status.Failed() != tt.failed || !status.Time.Equal(start) {
+				t.Errorf("expect failed %v, got %+v", tt.failed, status)
+			}
+			if f.reconciler.State().Reloads["web.tmpl"] != status {
+				t.Errorf("expect reload status in state, got %+v", f.reconciler.State().Reloads)
+			}
+		})
+	}
+}
+
+func TestRun(t *testing.T) {
+	f := newFixture()
+	f.cloud.Launch(awstest.Instance{Group: "web-asg", PrivateIP: "10.0.0.1"})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() {
+		done <- f.reconciler.Run(ctx)
+	}()
+
+	// Wait for an iteration to complete and Run to sleep
+	waitIteration := func() {
+		t.Helper()
+		for {
+			select {
+			case event := <-f.events:
+				if event != engine.IterationDone {
+					continue
+				}
+			case <-time.After(5 * time.Second):
+				t.Fatal("expect an iteration")
+			}
+			for f.clock.Waiters() == 0 {
+				time.Sleep(time.Millisecond)
+			}
+			return
+		}
+	}
+
+	waitIteration()
+	if content := f.fs.Content("/run/web.conf"); content != "server 10.0.0.1\n" {
+		t.Errorf("expect first iteration, got %q", content)
+	}
+
+	// Iterate again once the interval elapsed
+	f.cloud.Launch(awstest.Instance{Group: "web-asg", PrivateIP: "10.0.0.2"})
+	f.clock.Advance(time.Minute)
+	waitIteration()
+	if content := f.fs.Content("/run/web.conf"); content != "server 10.0.0.1\nserver 10.0.0.2\n" {
+		t.Errorf("expect second iteration, got %q", content)
+	}
+
+	// Iterate immediately when triggered
+	f.cloud.Launch(awstest.Instance{Group: "web-asg", PrivateIP: "10.0.0.3"})
+	f.reconciler.Trigger()
+	waitIteration()
+	if content := f.fs.Content("/run/web.conf"); content != "server 10.0.0.1\nserver 10.0.0.2\nserver 10.0.0.3\n" {
+		t.Errorf("expect triggered iteration, got %q", content)
+	}
+
+	cancel()
+	if err := <-done; !errors.Is(err, context.Canceled) {
+		t.Errorf("expect %v, got %v", context.Canceled, err)
+	}
+}
+
+func TestValidate(t *testing.T) {
+
+	cases := []struct {
+		resource string
+		template string
+		expect   string
+	}{
+		/* Valid resource and template */
+		{
+			resource: "[template]\nsrc = \"db.tmpl\"\ndest = \"/run/db.conf\"\ntags = [\"db\"]\n",
+			template: "{{range index . \"db\"}}{{.}}{{end}}",
+		},
+		/* Invalid resource */
+		{
+			resource: "[template]\nsrc = \"db.tmpl\"\ntags = [\"db\"]\n",
+			template: "{{range index . \"db\"}}{{.}}{{end}}",
+			expect:   "db.toml: src and dest must be set together",
+		},
+		/* Invalid template */
+		{
+			resource: "[template]\nsrc = \"db.tmpl\"\ndest = \"/run/db.conf\"\ntags = [\"db\"]\n",
+			template: "{{range index . \"db\"}}{{.}}",
+			expect:   "db.toml: template: db.tmpl:1: unexpected EOF",
+		},
+		/* Name given to different lookables of a resource */
+		{
+			resource: "[template]\nsrc = \"db.tmpl\"\ndest = \"/run/db.conf\"\ndns = [{name = \"db.local\"}, {name = \"db.local\", type = \"SRV\"}]\n",
+			template: "{{range index . \"db.local\"}}{{.}}{{end}}",
+			expect:   "db.toml: lookable.DNS db.local watched with different settings",
+		},
+		/* Name given to different lookables of different resources */
+		{
+			resource: "[template]\nsrc = \"db.tmpl\"\ndest = \"/run/db.conf\"\ngroups = [\"db\"]\n",
+			template: "{{range index . \"db\"}}{{.}}{{end}}",
+			expect:   "lookables lookable.AutoScalingGroup and lookable.Tag share the name db",
+		},
+		/* Environment variable suffix given to different lookables of a resource */
+		{
+			resource: "[template]\nsrc = \"db.tmpl\"\ndest = \"/run/db.conf\"\ngroups = [\"db-asg\", \"db_asg\"]\n",
+			template: "{{range index . \"db-asg\"}}{{.}}{{end}}",
+			expect:   "db.toml: lookables db-asg and db_asg share the environment variable suffix DB_ASG",
+		},
+		/* Environment variable suffix given to different lookables of different resources */
+		{
+			resource: "[template]\nsrc = \"db.tmpl\"\ndest = \"/run/db.conf\"\ntags = [\"web.asg\"]\n",
+			template: "{{range index . \"web.asg\"}}{{.}}{{end}}",
+			expect:   "lookables web.asg and web-asg share the environment variable suffix WEB_ASG",
+		},
+	}
+
+	for i, tt := range cases {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			f := newFixture()
+			f.fs.Add("/etc/overlord/resources/db.toml", tt.resource, start)
+			f.fs.Add("/etc/overlord/templates/db.tmpl", tt.template, start)
+
+			err := f.reconciler.Validate()
+			if tt.expect == "" && err != nil {
+				t.Fatalf("expect no error, got %v", err)
+			}
+			if tt.expect != "" && (err == nil || err.Error() != tt.expect) {
+				t.Errorf("expect %v, got %v", tt.expect, err)
+			}
+		})
+	}
+}
+
+func TestRender(t *testing.T) {
+	f := newFixture()
+	f.cloud.Launch(awstest.Instance{Group: "web-asg", PrivateIP: "10.0.0.2"})
+	f.cloud.Launch(awstest.Instance{Group: "web-asg", PrivateIP: "10.0.0.1"})
+
+	var output strings.Builder
+	if err := f.reconciler.Render(context.Background(), "web", &output); err != nil {
+		t.Fatal(err)
+	}
+	if expect := "server 10.0.0.1\nserver 10.0.0.2\n"; output.String() != expect {
+		t.Errorf("expect %q, got %q", expect, output.String())
+	}
+	if content := f.fs.Content("/run/web.conf"); content != "" {
+		t.Errorf("expect dest untouched, got %q", content)
+	}
+	if calls := f.runner.Calls(); len(calls) != 0 {
+		t.Errorf("expect no reload, got %v", calls)
+	}
+}
+
+func TestPlan(t *testing.T) {
+	f := newFixture()
+	f.cloud.Launch(awstest.Instance{Group: "web-asg", PrivateIP: "10.0.0.1"})
+	f.fs.Add("/run/web.conf", "server 10.0.0.9\n", start)
+
+	result, err := f.reconciler.Plan(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(result.Diffs) != 1 {
+		t.Fatalf("expect 1 diff, got %v", result.Diffs)
+	}
+	diff := result.Diffs[0]
+	if diff.Current != "server 10.0.0.9\n" || diff.Rendered != "server 10.0.0.1\n" {
+		t.Errorf("expect diff from %q to %q, got %q to %q", "server 10.0.0.9\n", "server 10.0.0.1\n", diff.Current, diff.Rendered)
+	}
+
+	if len(result.Reloads) != 1 {
+		t.Fatalf("expect 1 reload, got %v", result.Reloads)
+	}
+	reload := result.Reloads[0]
+	if reload.Status != nil {
+		t.Errorf("expect planned reload, got %v", reload.Status)
+	}
+	if added := reload.Changes.Added(); !slices.Equal(added, []string{"10.0.0.1"}) {
+		t.Errorf("expect IP_ADDED %v, got %v", []string{"10.0.0.1"}, added)
+	}
+
+	if content := f.fs.Content("/run/web.conf"); content != "server 10.0.0.9\n" {
+		t.Errorf("expect dest untouched, got %q", content)
+	}
+	if calls := f.runner.Calls(); len(calls) != 0 {
+		t.Errorf("expect no reload, got %v", calls)
+	}
+	if events := f.receivedEvents(); len(events) != 0 {
+		t.Errorf("expect no event, got %v", events)
+	}
+
+	// The state is left for the next iteration to apply the same changes
+	if _, err := f.reconciler.RunOnce(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if content := f.fs.Content("/run/web.conf"); content != "server 10.0.0.1\n" {
+		t.Errorf("expect %q, got %q", "server 10.0.0.1\n", content)
+	}
+	if calls := f.runner.Calls(); len(calls) != 1 {
+		t.Errorf("expect 1 reload, got %v", calls)
+	}
+}
+
+func TestRollback(t *testing.T) {
+	f := newFixture()
+	f.fs.Add("/etc/overlord/resources/web.toml", `
+[template]
+src = "web.tmpl"
+dest = "/run/web.conf"
+groups = ["web-asg"]
+reload_cmd = "reload web"
+backups = 2
+`, start)
+	f.cloud.Launch(awstest.Instance{ID: "i-1", Group: "web-asg", PrivateIP: "10.0.0.1"})
+	if _, err := f.reconciler.RunOnce(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	f.runner.Calls()
+
+	// The failed reload restores the previous version from the backups kept on FS
+	f.cloud.Launch(awstest.Instance{ID: "i-2", Group: "web-asg", PrivateIP: "10.0.0.2"})
+	f.runner.FailWith(1)
+	result, err := f.reconciler.RunOnce(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if content := f.fs.Content("/run/web.conf"); content != "server 10.0.0.1\n" {
+		t.Errorf("expect %q, got %q", "server 10.0.0.1\n", content)
+	}
+	if status := result.Reloads[0].Status; !status.Failed() || !status.RolledBack {
+		t.Errorf("expect rolled back failure, got %+v", status)
+	}
+	calls := f.runner.Calls()
+	if len(calls) != 2 || calls[1].Getenv("IP_ADDED") != "" {
+		t.Errorf("expect reload of the previous version, got %v", calls)
+	}
+
+	// The rolled back content is not applied again, even when a reload is triggered
+	f.reconciler.Trigger()
+	if _, err := f.reconciler.RunOnce(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if content := f.fs.Content("/run/web.conf"); content != "server 10.0.0.1\n" {
+		t.Errorf("expect %q, got %q", "server 10.0.0.1\n", content)
+	}
+	if calls := f.runner.Calls(); len(calls) != 0 {
+		t.Errorf("expect no reload, got %v", calls)
+	}
+
+	// It is retried once the IPs changed
+	f.cloud.Launch(awstest.Instance{ID: "i-3", Group: "web-asg", PrivateIP: "10.0.0.3"})
+	if _, err := f.reconciler.RunOnce(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if content := f.fs.Content("/run/web.conf"); content != "server 10.0.0.1\nserver 10.0.0.2\nserver 10.0.0.3\n" {
+		t.Errorf("expect %q, got %q", "server 10.0.0.1\nserver 10.0.0.2\nserver 10.0.0.3\n", content)
+	}
+	if calls := f.runner.Calls(); len(calls) != 1 {
+		t.Errorf("expect 1 reload, got %v", calls)
+	}
+	if hash, exists := f.reconciler.State().RolledBack["web.tmpl"]; exists {
+		t.Errorf("expect rolled back content forgotten, got %v", hash)
+	}
+
+	// Rendering the same content again does not push the previous version out of the backups
+	f.fs.Add("/etc/overlord/templates/web.tmpl", f.fs.Content("/etc/overlord/templates/web.tmpl"), start.Add(time.Hour))
+	if _, err := f.reconciler.RunOnce(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if content := f.fs.Content("/var/lib/overlord/backups/%2Frun%2Fweb.conf/1"); content != "server 10.0.0.1\n" {
+		t.Errorf("expect %q, got %q", "server 10.0.0.1\n", content)
+	}
+	if content := f.fs.Content("/var/lib/overlord/backups/%2Frun%2Fweb.conf/2"); content != "" {
+		t.Errorf("expect a single version, got %q", content)
+	}
+}
+
+func TestReloadRunner(t *testing.T) {
+
+	cases := []struct {
+		reload string
+		expect enginetest.Call
+	}{
+		/* Signal sent to the process of a pid file */
+		{
+			reload: "reload_signal = \"SIGHUP\"\npid_file = \"/run/web.pid\"\n",
+			expect: enginetest.Call{Signal: syscall.SIGHUP, PidFile: "/run/web.pid"},
+		},
+		/* Signal sent to the processes of a name */
+		{
+			reload: "reload_signal = \"USR2\"\nprocess_name = \"web\"\n",
+			expect: enginetest.Call{Signal: syscall.SIGUSR2, ProcessName: "web"},
+		},
+		/* Unit job */
+		{
+			reload: "reload_unit = \"web.service\"\n",
+			expect: enginetest.Call{Unit: "web.service", Action: "reload"},
+		},
+	}
+
+	for i, tt := range cases {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			f := newFixture()
+			f.fs.Add("/etc/overlord/resources/web.toml", "[template]\nsrc = \"web.tmpl\"\ndest = \"/run/web.conf\"\ngroups = [\"web-asg\"]\n"+tt.reload, start)
+			f.cloud.Launch(awstest.Instance{Group: "web-asg", PrivateIP: "10.0.0.1"})
+
+			result, err := f.reconciler.RunOnce(context.Background())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if status := result.Reloads[0].Status; status.Failed() {
+				t.Errorf("expect successful reload, got %+v", status)
+			}
+			calls := f.runner.Calls()
+			if len(calls) != 1 || *calls[0] != tt.expect {
+				t.Errorf("expect %+v, got %v", tt.expect, calls)
+			}
+		})
+	}
+}
+
+func TestRuntime(t *testing.T) {
+	const serversState = `1
+# be_id be_name srv_id srv_name srv_addr srv_op_state srv_admin_state srv_uweight srv_iweight srv_time_since_last_change srv_check_status srv_check_result srv_check_health srv_check_state srv_agent_state bk_f_forced_id srv_f_forced_id srv_fqdn srv_port srvrecord
+3 be_app 1 srv1 10.0.0.1 2 0 1 1 10 6 3 4 6 0 0 0 - 80 -
+3 be_app 2 srv2 10.0.0.2 2 0 1 1 10 6 3 4 6 0 0 0 - 80 -
+3 be_app 3 srv3 0.0.0.0 0 5 1 1 10 1 0 0 14 0 0 0 - 80 -
+`
+
+	cases := []struct {
+		// Additional resource settings
+		settings string
+		scenario func(c *awstest.Cloud, fs *enginetest.FS)
+		// Whether the servers were set through the socket, the update kept, and the reload command run
+		sync    bool
+		runtime bool
+		reload  bool
+	}{
+		/* Only the IPs of a mapped group changed */
+		{
+			scenario: func(c *awstest.Cloud, fs *enginetest.FS) {
+				c.Launch(awstest.Instance{Group: "web-asg", PrivateIP: "10.0.0.3"})
+			},
+			sync:    true,
+			runtime: true,
+		},
+		/* Not enough server slots */
+		{
+			scenario: func(c *awstest.Cloud, fs *enginetest.FS) {
+				c.Launch(awstest.Instance{Group: "web-asg", PrivateIP: "10.0.0.3"})
+				c.Launch(awstest.Instance{Group: "web-asg", PrivateIP: "10.0.0.4"})
+			},
+			reload: true,
+		},
+		/* Changed group without backend */
+		{
+			scenario: func(c *awstest.Cloud, fs *enginetest.FS) {
+				c.Launch(awstest.Instance{Name: "db", PrivateIP: "10.0.1.1"})
+			},
+			reload: true,
+		},
+		/* Template changed */
+		{
+			scenario: func(c *awstest.Cloud, fs *enginetest.FS) {
+				c.Launch(awstest.Instance{Group: "web-asg", PrivateIP: "10.0.0.3"})
+				fs.Add("/etc/overlord/templates/web.tmpl", `{{range index . "web-asg"}}server {{.}} check
+{{end}}`, start.Add(time.Hour))
+			},
+			reload: true,
+		},
+		/* Verify probe failed after the update */
+		{
+			settings: "[template.verify]\ncmd = \"test -e /nonexistent\"\n",
+			scenario: func(c *awstest.Cloud, fs *enginetest.FS) {
+				c.Launch(awstest.Instance{Group: "web-asg", PrivateIP: "10.0.0.3"})
+			},
+			sync:   true,
+			reload: true,
+		},
+	}
+
+	for i, tt := range cases {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			socket := haproxytest.NewSocket(t, map[string]string{"be_app": serversState})
+			f := newFixture()
+			f.fs.Add("/etc/overlord/resources/web.toml", `
+[template]
+src = "web.tmpl"
+dest = "/run/web.conf"
+groups = ["web-asg"]
+tags = ["db"]
+reload_cmd = "reload web"
+`+tt.settings+`
+[template.haproxy]
+socket = "`+socket.Path+`"
+port = 8080
+[template.haproxy.backends]
+"web-asg" = "be_app"
+`, start)
+			f.cloud.Launch(awstest.Instance{Group: "web-asg", PrivateIP: "10.0.0.1"})
+			f.cloud.Launch(awstest.Instance{Group: "web-asg", PrivateIP: "10.0.0.2"})
+
+			// The first iteration reloads the new template
+			if _, err := f.reconciler.RunOnce(context.Background()); err != nil {
+				t.Fatal(err)
+			}
+			if calls := f.runner.Calls(); len(calls) != 1 {
+				t.Fatalf("expect first reload, got %v", calls)
+			}
+			if commands := socket.Commands(); len(commands) != 0 {
+				t.Fatalf("expect no servers set, got %v", commands)
+			}
+
+			tt.scenario(f.cloud, f.fs)
+			result, err := f.reconciler.RunOnce(context.Background())
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if len(result.Reloads) != 1 || result.Reloads[0].Status.Runtime != tt.runtime {
+				t.Errorf("expect runtime %v, got %v", tt.runtime, result.Reloads)
+			}
+			if calls := f.runner.Calls(); (len(calls) == 1) != tt.reload {
+				t.Errorf("expect reload %v, got %v", tt.reload, calls)
+			}
+			var expect []string
+			if tt.sync {
+				expect = []string{"set server be_app/srv3 addr 10.0.0.3 port 8080", "set server be_app/srv3 state ready"}
+			}
+			if commands := socket.Commands(); !slices.Equal(commands, expect) {
+				t.Errorf("expect %v, got %v", expect, commands)
+			}
+		})
+	}
+}
+
+// checkRunner is a Runner calling check before recording each command.
+type checkRunner struct {
+	*enginetest.Runner
+	check func()
+}
+
+func (r checkRunner) Run(ctx context.Context, cmd *engine.Command, stdout, stderr io.Writer) (int, error) {
+	r.check()
+	return r.Runner.Run(ctx, cmd, stdout, stderr)
+}
+
+func TestReloadGroup(t *testing.T) {
+
+	cases := []struct {
+		web string
+		api string
+		// Reload commands run, in order
+		calls []string
+	}{
+		/* Same reload group */
+		{
+			web:   "reload_cmd = \"reload web\"\nreload_group = \"front\"\n",
+			api:   "reload_cmd = \"reload api\"\nreload_group = \"front\"\n",
+			calls: []string{"reload api"},
+		},
+		/* Identical reload command */
+		{
+			web:   "reload_cmd = \"reload front\"\nreload_timeout = \"10s\"\n",
+			api:   "reload_cmd = \"reload front\"\nreload_timeout = \"10s\"\n",
+			calls: []string{"reload front"},
+		},
+		/* Identical reload command run from other directories */
+		{
+			web:   "reload_cmd = \"reload front\"\nreload_dir = \"/srv/web\"\n",
+			api:   "reload_cmd = \"reload front\"\nreload_dir = \"/srv/api\"\n",
+			calls: []string{"reload front", "reload front"},
+		},
+		/* Identical reload command with other environments */
+		{
+			web:   "reload_cmd = \"reload front\"\nreload_env = { SITE = \"web\" }\n",
+			api:   "reload_cmd = \"reload front\"\nreload_env = { SITE = \"api\" }\n",
+			calls: []string{"reload front", "reload front"},
+		},
+		/* Identical command line given as a script and as arguments */
+		{
+			web:   "reload_cmd = \"reload front\"\n",
+			api:   "reload_argv = [\"reload\", \"front\"]\n",
+			calls: []string{"reload front", "reload front"},
+		},
+	}
+
+	for i, tt := range cases {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			f := newFixture()
+			f.fs.Add("/etc/overlord/resources/web.toml", "[template]\nsrc = \"web.tmpl\"\ndest = \"/run/web.conf\"\ngroups = [\"web-asg\"]\n"+tt.web, start)
+			f.fs.Add("/etc/overlord/resources/api.toml", "[template]\nsrc = \"api.tmpl\"\ndest = \"/run/api.conf\"\ntags = [\"api\"]\n"+tt.api, start)
+			f.fs.Add("/etc/overlord/templates/api.tmpl", `{{range index . "api"}}api {{.}}
+{{end}}`, start)
+			f.cloud.Launch(awstest.Instance{ID: "i-1", Group: "web-asg", PrivateIP: "10.0.0.1"})
+			f.cloud.Launch(awstest.Instance{ID: "i-2", Name: "api", PrivateIP: "10.0.2.1"})
+
+			// Both dest files are generated before any reload
+			var written []bool
+			reconciler := engine.New(engine.Config{
+				Dir:     "/etc/overlord",
+				Clients: f.cloud.Clients(),
+				FS:      f.fs,
+				Runner: checkRunner{Runner: f.runner, check: func() {
+					written = append(written, f.fs.Content("/run/web.conf") != "" && f.fs.Content("/run/api.conf") != "")
+				}},
+				Clock: f.clock,
+			})
+			if _, err := reconciler.RunOnce(context.Background()); err != nil {
+				t.Fatal(err)
+			}
+
+			calls := f.runner.Calls()
+			var commands []string
+			for _, call := range calls {
+				commands = append(commands, call.Command.String())
+			}
+			if !slices.Equal(commands, tt.calls) {
+				t.Fatalf("expect %v, got %v", tt.calls, commands)
+			}
+			if slices.Contains(written, false) {
+				t.Errorf("expect dest files written before reload, got %v", written)
+			}
+
+			if len(calls) == 1 {
+				if added := calls[0].Getenv("IP_ADDED"); added != "10.0.0.1 10.0.2.1" {
+					t.Errorf("expect merged IP_ADDED %q, got %q", "10.0.0.1 10.0.2.1", added)
+				}
+				if resources := calls[0].Getenv("OVERLORD_RESOURCE"); resources != "api.tmpl web.tmpl" {
+					t.Errorf("expect resources %q, got %q", "api.tmpl web.tmpl", resources)
+				}
+			}
+
+			// Removals are merged the same way
+			f.cloud.Terminate("i-1")
+			f.cloud.Terminate("i-2")
+			if _, err := reconciler.RunOnce(context.Background()); err != nil {
+				t.Fatal(err)
+			}
+			calls = f.runner.Calls()
+			if len(calls) != len(tt.calls) {
+				t.Fatalf("expect %v, got %v", tt.calls, calls)
+			}
+			if len(calls) == 1 {
+				if removed := calls[0].Getenv("IP_REMOVED"); removed != "10.0.0.1 10.0.2.1" {
+					t.Errorf("expect merged IP_REMOVED %q, got %q", "10.0.0.1 10.0.2.1", removed)
+				}
+			}
+		})
+	}
+}
+
+func TestRenderFixture(t *testing.T) {
+	f := newFixture()
+
+	var output strings.Builder
+	ips := inventory.Inventory{"web-asg": {{IP: "10.0.0.1"}}, "db": {{IP: "10.0.1.1"}}}
+	if err := f.reconciler.RenderFixture("web.toml", ips, &output); err != nil {
+		t.Fatal(err)
+	}
+	if expect := "server 10.0.0.1\ndb 10.0.1.1\n"; output.String() != expect {
+		t.Errorf("expect %q, got %q", expect, output.String())
+	}
+	if calls := f.cloud.Calls("DescribeAutoScalingGroups"); calls != 0 {
+		t.Errorf("expect no lookup, got %d", calls)
+	}
+}
+
+func TestGolden(t *testing.T) {
+	const golden = "/etc/overlord/templates/web.tmpl.golden"
+	defaultFixture := inventory.Inventory{"web-asg": {{IP: "10.0.0.1"}}}
+
+	cases := []struct {
+		files   map[string]string
+		fixture inventory.Inventory
+		update  bool
+		err     bool
+		// Expected outcome of web.tmpl, no result expected when empty
+		rendered string
+		passed   bool
+	}{
+		/* No golden file */
+		{
+			fixture: defaultFixture,
+		},
+		/* Golden file matching the default fixture */
+		{
+			files:    map[string]string{golden: "server 10.0.0.1\n"},
+			fixture:  defaultFixture,
+			rendered: "server 10.0.0.1\n",
+			passed:   true,
+		},
+		/* Golden file not matching */
+		{
+			files:    map[string]string{golden: "server 10.0.0.2\n"},
+			fixture:  defaultFixture,
+			rendered: "server 10.0.0.1\n",
+		},
+		/* Fixture next to the template preferred over the default one */
+		{
+			files: map[string]string{
+				golden: "server 10.0.0.2\n",
+				"/etc/overlord/templates/web.tmpl.fixture.json": `{"web-asg": ["10.0.0.2"]}`,
+			},
+			fixture:  defaultFixture,
+			rendered: "server 10.0.0.2\n",
+			passed:   true,
+		},
+		/* Golden file without any fixture */
+		{
+			files: map[string]string{golden: "server 10.0.0.1\n"},
+			err:   true,
+		},
+		/* Golden file created by update */
+		{
+			fixture:  defaultFixture,
+			update:   true,
+			rendered: "server 10.0.0.1\n",
+		},
+	}
+
+	for i, tt := range cases {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			f := newFixture()
+			for name, content := range tt.files {
+				f.fs.Add(name, content, start)
+			}
+
+			results, err := f.reconciler.Test(tt.fixture, tt.update)
+			if tt.err {
+				if err == nil {
+					t.Errorf("expect an error, got %v", results)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if tt.rendered == "" {
+				if len(results) != 0 {
+					t.Errorf("expect no result, got %v", results)
+				}
+				return
+			}
+			if len(results) != 1 {
+				t.Fatalf("expect 1 result, got %v", results)
+			}
+			if results[0].Rendered != tt.rendered {
+				t.Errorf("expect %q, got %q", tt.rendered, results[0].Rendered)
+			}
+			if results[0].Passed() != tt.passed {
+				t.Errorf("expect passed %v, got %v", tt.passed, results[0].Passed())
+			}
+			if results[0].Updated != tt.update {
+				t.Errorf("expect updated %v, got %v", tt.update, results[0].Updated)
+			}
+			if tt.update && f.fs.Content(golden) != tt.rendered {
+				t.Errorf("expect golden file %q, got %q", tt.rendered, f.fs.Content(golden))
+			}
+		})
+	}
+}
+
+func TestFileMembers(t *testing.T) {
+	f := newFixture()
+	path := filepath.Join(t.TempDir(), "api.yaml")
+	f.fs.Add("/etc/overlord/resources/api.toml", `
+[template]
+src = "api.tmpl"
+dest = "/run/api.conf"
+files = ["`+path+`"]
+reload_cmd = "reload api"
+`, start)
+	f.fs.Add("/etc/overlord/templates/api.tmpl", `{{range members "`+path+`"}}server {{.IP}}:{{.Port}} weight {{index .Meta "weight"}}
+{{end}}`, start)
+
+	cases := []struct {
+		members string
+		content string
+		reload  bool
+	}{
+		/* First iteration */
+		{
+			members: "- {ip: 10.0.0.1, port: 8080, meta: {weight: '1'}}\n",
+			content: "server 10.0.0.1:8080 weight 1\n",
+			reload:  true,
+		},
+		/* Nothing changed */
+		{
+			members: "- {ip: 10.0.0.1, port: 8080, meta: {weight: '1'}}\n",
+			content: "server 10.0.0.1:8080 weight 1\n",
+		},
+		/* Only the metadata changed */
+		{
+			members: "- {ip: 10.0.0.1, port: 8080, meta: {weight: '2'}}\n",
+			content: "server 10.0.0.1:8080 weight 2\n",
+			reload:  true,
+		},
+	}
+
+	for i, tt := range cases {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			if err := os.WriteFile(path, []byte(tt.members), 0644); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := f.reconciler.RunOnce(context.Background()); err != nil {
+				t.Fatal(err)
+			}
+
+			if content := f.fs.Content("/run/api.conf"); content != tt.content {
+				t.Errorf("expect %q, got %q", tt.content, content)
+			}
+			reloaded := slices.ContainsFunc(f.runner.Calls(), func(call *enginetest.Call) bool {
+				return call.Command.String() == "reload api"
+			})
+			if reloaded != tt.reload {
+				t.Errorf("expect reload %v, got %v", tt.reload, reloaded)
+			}
+		})
+	}
+}
+
+func TestRunWatch(t *testing.T) {
+	f := newFixture()
+
+	// Fake Consul whose blocking queries return once entries are received
+	var (
+		mu      sync.Mutex
+		index   = 1
+		entries = "[]"
+	)
+	updates := make(chan string)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.URL.Query().Has("index") {
+			select {
+			case update := <-updates:
+				mu.Lock()
+				index++
+				entries = update
+				mu.Unlock()
+			case <-req.Context().Done():
+				return
+			}
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		w.Header().Set("X-Consul-Index", strconv.Itoa(index))
+		w.Write([]byte(entries))
+	}))
+	defer server.Close()
+
+	f.fs.Add("/etc/overlord/resources/api.toml", `
+[template]
+src = "api.tmpl"
+dest = "/run/api.conf"
+consul = [{service = "api", address = "`+server.URL+`"}]
+reload_cmd = "reload api"
+`, start)
+	f.fs.Add("/etc/overlord/templates/api.tmpl", `{{range members "api"}}server {{.IP}}:{{.Port}}
+{{end}}`, start)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() {
+		done <- f.reconciler.Run(ctx)
+	}()
+
+	waitContent := func(expect string) {
+		t.Helper()
+		deadline := time.Now().Add(5 * time.Second)
+		for f.fs.Content("/run/api.conf") != expect {
+			if time.Now().After(deadline) {
+				t.Fatalf("expect %q, got %q", expect, f.fs.Content("/run/api.conf"))
+			}
+			time.Sleep(time.Millisecond)
+		}
+	}
+
+	waitContent("")
+	// The change of the service wakes Run up before the interval elapsed
+	updates <- `[{"Node": {"Address": "10.0.0.1"}, "Service": {"ID": "api-1", "Port": 8080}}]`
+	waitContent("server 10.0.0.1:8080\n")
+
+	cancel()
+	if err := <-done; !errors.Is(err, context.Canceled) {
+		t.Errorf("expect %v, got %v", context.Canceled, err)
+	}
+}
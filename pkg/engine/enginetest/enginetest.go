@@ -0,0 +1,253 @@
+package enginetest
+
+// Fakes of the engine filesystem, command runner and clock
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"strings"
+	"sync"
+	"syscall"
+	"testing/fstest"
+	"time"
+
+	"github.com/AirVantage/overlord/pkg/engine"
+)
+
+// FS is an in-memory engine.FS. Absolute paths are stored without their leading slash.
+type FS struct {
+	mu    sync.Mutex
+	files fstest.MapFS
+}
+
+// NewFS returns a pointer to an empty FS.
+func NewFS() *FS {
+	return &FS{files: make(fstest.MapFS)}
+}
+
+func key(name string) string {
+	return strings.TrimPrefix(name, "/")
+}
+
+// Add a file modified at modTime.
+func (f *FS) Add(name, content string, modTime time.Time) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.files[key(name)] = &fstest.MapFile{Data: []byte(content), Mode: 0644, ModTime: modTime}
+}
+
+// Content of a file, empty when it does not exist.
+func (f *FS) Content(name string) string {
+	content, _ := f.ReadFile(name)
+	return string(content)
+}
+
+func (f *FS) ReadDir(name string) ([]fs.DirEntry, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.files.ReadDir(key(name))
+}
+
+func (f *FS) ReadFile(name string) ([]byte, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.files.ReadFile(key(name))
+}
+
+func (f *FS) Stat(name string) (fs.FileInfo, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.files.Stat(key(name))
+}
+
+// MkdirAll does nothing, directories exist as soon as they hold a file.
+func (f *FS) MkdirAll(path string, perm fs.FileMode) error {
+	return nil
+}
+
+func (f *FS) WriteFile(name string, data []byte, perm fs.FileMode) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.files[key(name)] = &fstest.MapFile{Data: append([]byte{}, data...), Mode: perm}
+	return nil
+}
+
+func (f *FS) Rename(oldpath, newpath string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	file, exists := f.files[key(oldpath)]
+	if !exists {
+		return &fs.PathError{Op: "rename", Path: oldpath, Err: fs.ErrNotExist}
+	}
+	f.files[key(newpath)] = file
+	delete(f.files, key(oldpath))
+	return nil
+}
+
+func (f *FS) Remove(name string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if _, exists := f.files[key(name)]; !exists {
+		return &fs.PathError{Op: "remove", Path: name, Err: fs.ErrNotExist}
+	}
+	delete(f.files, key(name))
+	return nil
+}
+
+// Call is a command run, a signal sent or a unit job run by a Runner.
+type Call struct {
+	// Command run, nil for signals and unit jobs
+	Command *engine.Command
+	// Content of the OVERLORD_CHANGES file at the time of the call
+	Changes string
+	// Signal sent to the process of PidFile, or to the processes named ProcessName
+	Signal      syscall.Signal
+	PidFile     string
+	ProcessName string
+	// Action job run on Unit
+	Unit   string
+	Action string
+}
+
+// Getenv returns the value of an environment variable added to the command.
+func (c *Call) Getenv(name string) string {
+	if c.Command == nil {
+		return ""
+	}
+	for _, variable := range c.Command.Env {
+		if value, found := strings.CutPrefix(variable, name+"="); found {
+			return value
+		}
+	}
+	return ""
+}
+
+// Runner is an engine.Runner recording the commands, signals and unit jobs instead of running them.
+type Runner struct {
+	mu    sync.Mutex
+	calls []*Call
+	// Exit codes of the next calls, in order, 0 afterwards
+	exitCodes []int
+}
+
+// FailWith makes the next calls exit with codes, one per call.
+func (r *Runner) FailWith(codes ...int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.exitCodes = append(r.exitCodes, codes...)
+}
+
+// Calls returns the recorded calls and forgets about them.
+func (r *Runner) Calls() []*Call {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	calls := r.calls
+	r.calls = nil
+	return calls
+}
+
+func (r *Runner) Run(ctx context.Context, cmd *engine.Command, stdout, stderr io.Writer) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	call := &Call{Command: cmd}
+	if changes, err := os.ReadFile(call.Getenv("OVERLORD_CHANGES")); err == nil {
+		call.Changes = string(changes)
+	}
+	return r.record(call)
+}
+
+// Signal records the signal, the signaled pid is always 1.
+func (r *Runner) Signal(pidFile, name string, sig syscall.Signal) ([]int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, err := r.record(&Call{Signal: sig, PidFile: pidFile, ProcessName: name}); err != nil {
+		return nil, err
+	}
+	return []int{1}, nil
+}
+
+func (r *Runner) RunUnit(ctx context.Context, unit, action string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	_, err := r.record(&Call{Unit: unit, Action: action})
+	return err
+}
+
+// Record a call, and return the next exit code.
+func (r *Runner) record(call *Call) (int, error) {
+	r.calls = append(r.calls, call)
+
+	exitCode := 0
+	if len(r.exitCodes) > 0 {
+		exitCode = r.exitCodes[0]
+		r.exitCodes = r.exitCodes[1:]
+	}
+	if exitCode != 0 {
+		return exitCode, fmt.Errorf("exit status %d", exitCode)
+	}
+	return 0, nil
+}
+
+// Clock is a manual engine.Clock, its time only changes through Advance.
+type Clock struct {
+	mu      sync.Mutex
+	now     time.Time
+	waiters []waiter
+}
+
+type waiter struct {
+	deadline time.Time
+	c        chan time.Time
+}
+
+// NewClock returns a pointer to a Clock set at now.
+func NewClock(now time.Time) *Clock {
+	return &Clock{now: now}
+}
+
+func (c *Clock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *Clock) After(d time.Duration) <-chan time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	w := waiter{deadline: c.now.Add(d), c: make(chan time.Time, 1)}
+	if d <= 0 {
+		w.c <- c.now
+		return w.c
+	}
+	c.waiters = append(c.waiters, w)
+	return w.c
+}
+
+// Advance the time by d, firing the channels of After whose deadline passed.
+func (c *Clock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.now = c.now.Add(d)
+	waiting := c.waiters[:0]
+	for _, w := range c.waiters {
+		if w.deadline.After(c.now) {
+			waiting = append(waiting, w)
+			continue
+		}
+		w.c <- c.now
+	}
+	c.waiters = waiting
+}
+
+// Waiters returns the number of channels of After not fired yet.
+func (c *Clock) Waiters() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.waiters)
+}
@@ -0,0 +1,107 @@
+package engine
+
+import (
+	"encoding/json"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/AirVantage/overlord/pkg/resource"
+)
+
+// Formats an environment variable for one or more values.
+func mkEnvVar(name string, values []string) string {
+	return name + "=" + strings.Join(values, " ")
+}
+
+// Returns a sorted copy of values.
+func sorted(values []string) []string {
+	values = append([]string{}, values...)
+	sort.Strings(values)
+	return values
+}
+
+// Environment variables describing the changes of a reload group:
+// merged and per lookable IP changes, current IPs, resources and path to the JSON changes file.
+func (g *reloadGroup) env(changesFile string) []string {
+	templates := make([]string, 0, len(g.resources))
+	dests := make([]string, 0, len(g.resources))
+	for _, resource := range g.resources {
+		templates = append(templates, resource.Src)
+		dests = append(dests, resource.Dest)
+	}
+
+	env := []string{
+		mkEnvVar("IP_ADDED", sorted(g.changes.Added())),
+		mkEnvVar("IP_REMOVED", sorted(g.changes.Removed())),
+		mkEnvVar("OVERLORD_RESOURCE", templates),
+		mkEnvVar("OVERLORD_DEST", dests),
+		"OVERLORD_CHANGES=" + changesFile,
+	}
+
+	names := make([]string, 0, len(g.groupChanges))
+	for name := range g.groupChanges {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		suffix := resource.EnvSuffix(name)
+		env = append(env,
+			mkEnvVar("IP_ADDED_"+suffix, sorted(g.groupChanges[name].Added())),
+			mkEnvVar("IP_REMOVED_"+suffix, sorted(g.groupChanges[name].Removed())),
+			mkEnvVar("IP_CURRENT_"+suffix, g.current[name]))
+	}
+
+	return env
+}
+
+// changesDocument is the content of the JSON file given to reload commands through OVERLORD_CHANGES.
+type changesDocument struct {
+	Resources []changesResource          `json:"resources"`
+	Added     []string                   `json:"added"`
+	Removed   []string                   `json:"removed"`
+	Groups    map[string]changesLookable `json:"groups"`
+}
+
+type changesResource struct {
+	Src  string `json:"src"`
+	Dest string `json:"dest"`
+}
+
+type changesLookable struct {
+	Added   []string `json:"added"`
+	Removed []string `json:"removed"`
+	Current []string `json:"current"`
+}
+
+// Write the full change set of a reload group in a temporary JSON file and return its path.
+func writeChangesFile(g *reloadGroup) (string, error) {
+	doc := changesDocument{
+		Resources: make([]changesResource, 0, len(g.resources)),
+		Added:     sorted(g.changes.Added()),
+		Removed:   sorted(g.changes.Removed()),
+		Groups:    make(map[string]changesLookable),
+	}
+	for _, resource := range g.resources {
+		doc.Resources = append(doc.Resources, changesResource{Src: resource.Src, Dest: resource.Dest})
+	}
+	for name, lookableChanges := range g.groupChanges {
+		doc.Groups[name] = changesLookable{
+			Added:   sorted(lookableChanges.Added()),
+			Removed: sorted(lookableChanges.Removed()),
+			Current: append([]string{}, g.current[name]...),
+		}
+	}
+
+	file, err := os.CreateTemp("", "overlord-changes-*.json")
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	if err := json.NewEncoder(file).Encode(doc); err != nil {
+		os.Remove(file.Name())
+		return "", err
+	}
+	return file.Name(), nil
+}
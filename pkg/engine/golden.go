@@ -0,0 +1,112 @@
+package engine
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"path/filepath"
+
+	"github.com/AirVantage/overlord/pkg/inventory"
+	"github.com/AirVantage/overlord/pkg/resource"
+)
+
+// Suffixes of the files stored next to a template to test it.
+const (
+	GoldenSuffix  = ".golden"
+	FixtureSuffix = ".fixture"
+)
+
+// Golden is the outcome of rendering the template of a resource from a fixture, compared with its golden file.
+type Golden struct {
+	Resource *resource.Resource
+	// Path of the golden file
+	Path string
+	// Content of the golden file, before any update
+	Expected string
+	Rendered string
+	// Whether the golden file was written with the rendered content
+	Updated bool
+}
+
+// Passed reports whether the rendered template matches its golden file.
+func (g *Golden) Passed() bool {
+	return g.Expected == g.Rendered
+}
+
+// Test renders the template of every resource having a golden file, <src>.golden in the templates directory,
+// and compares it with its content. Templates are rendered from their own fixture, <src>.fixture.yaml, .yml
+// or .json, or from defaultFixture when they have none. When update is set, the golden files of all the
+// templates having a fixture are written with the rendered content instead.
+func (r *Reconciler) Test(defaultFixture inventory.Inventory, update bool) ([]*Golden, error) {
+	names, err := r.resourceFiles()
+	if err != nil {
+		return nil, err
+	}
+
+	var results []*Golden
+	for _, name := range names {
+		rc, err := r.loadResource(name)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", name, err)
+		}
+		if !rc.HasTemplate() {
+			continue
+		}
+
+		golden := &Golden{Resource: rc, Path: filepath.Join(r.config.Dir, TemplatesDir, rc.Src+GoldenSuffix)}
+		expected, err := r.config.FS.ReadFile(golden.Path)
+		exists := err == nil
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return nil, err
+		}
+		if !exists && !update {
+			continue
+		}
+		golden.Expected = string(expected)
+
+		members, err := r.templateFixture(rc)
+		if err != nil {
+			return nil, err
+		}
+		if members == nil {
+			members = defaultFixture
+		}
+		if members == nil {
+			if !exists {
+				continue
+			}
+			return nil, fmt.Errorf("%s: no fixture to render %s", name, rc.Src)
+		}
+
+		rendered, err := r.render(rc, members.IPs(), members)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", name, err)
+		}
+		golden.Rendered = string(rendered)
+
+		if update && !golden.Passed() {
+			if err := r.config.FS.WriteFile(golden.Path, rendered, 0666); err != nil {
+				return nil, err
+			}
+			golden.Updated = true
+		}
+		results = append(results, golden)
+	}
+	return results, nil
+}
+
+// Fixture stored next to the template of a resource, nil when there is none.
+func (r *Reconciler) templateFixture(rc *resource.Resource) (inventory.Inventory, error) {
+	for _, ext := range inventory.Extensions {
+		path := filepath.Join(r.config.Dir, TemplatesDir, rc.Src+FixtureSuffix+ext)
+		content, err := r.config.FS.ReadFile(path)
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		return inventory.Parse(path, content)
+	}
+	return nil, nil
+}
@@ -0,0 +1,484 @@
+package engine
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/AirVantage/overlord/pkg/changes"
+	"github.com/AirVantage/overlord/pkg/haproxy"
+	"github.com/AirVantage/overlord/pkg/process"
+	"github.com/AirVantage/overlord/pkg/resource"
+	"github.com/AirVantage/overlord/pkg/state"
+	"github.com/AirVantage/overlord/pkg/systemd"
+)
+
+// Maximum size of the reload command output kept for each stream
+const reloadOutputLimit = 16 * 1024
+
+// reloadGroup gathers the updated resources sharing the same reload command,
+// which is run once after all their dest files were generated.
+type reloadGroup struct {
+	key       string
+	resources []*resource.Resource
+	// Merged IP changes of all the resources
+	changes *changes.Changes[string]
+	// IP changes and current IPs of each lookable watched by the resources
+	groupChanges map[string]*changes.Changes[string]
+	current      map[string][]string
+	// Whether the reload command must be run, because a template changed or a reload was requested
+	full bool
+}
+
+// Group the updated resources by reload_group, or by identical reload command and settings.
+// Resources without reload command are left apart.
+func groupReloads(resourcesToUpdate map[*resource.Resource]*changes.Changes[string], fullReload map[*resource.Resource]bool, groupChanges map[string]*changes.Changes[string], ips map[string][]string) []*reloadGroup {
+	groups := make(map[string]*reloadGroup)
+
+	for rc, rcChanges := range resourcesToUpdate {
+		if !rc.HasReload() {
+			continue
+		}
+
+		key := rc.ReloadGroup
+		if key == "" {
+			key = reloadKey(rc)
+		}
+
+		if group, exists := groups[key]; exists {
+			group.resources = append(group.resources, rc)
+			group.changes = group.changes.Merge(rcChanges)
+		} else {
+			groups[key] = &reloadGroup{
+				key:          key,
+				resources:    []*resource.Resource{rc},
+				changes:      rcChanges,
+				groupChanges: make(map[string]*changes.Changes[string]),
+				current:      make(map[string][]string),
+			}
+		}
+
+		groups[key].full = groups[key].full || fullReload[rc]
+
+		for _, l := range rc.Lookables() {
+			name := l.String()
+			groups[key].current[name] = ips[name]
+			if lookableChanges, exists := groupChanges[name]; exists {
+				groups[key].groupChanges[name] = lookableChanges
+			} else {
+				groups[key].groupChanges[name] = changes.New[string]()
+			}
+		}
+	}
+
+	// Sort groups and resources so that reloads run in a stable order
+	sorted := make([]*reloadGroup, 0, len(groups))
+	for _, group := range groups {
+		sort.Slice(group.resources, func(i, j int) bool { return group.resources[i].Src < group.resources[j].Src })
+		for _, resource := range group.resources[1:] {
+			if reloadKey(resource) != reloadKey(group.leader()) {
+				slog.Warn("Resources of the same reload group have different reload settings, using the ones of the first one",
+					"reload_group", group.key,
+					"resource_template", resource.Src,
+					"cmd", group.leader().Command())
+			}
+		}
+		sorted = append(sorted, group)
+	}
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].key < sorted[j].key })
+
+	return sorted
+}
+
+// Key of the implicit reload group of a resource: its reload command and the settings it is run with.
+func reloadKey(rc *resource.Resource) string {
+	cmd := reloadCommand(rc)
+	fields := []string{rc.Command(), strings.Join(cmd.Argv, "\x00"), cmd.Dir, cmd.User, rc.ReloadTimeout.String()}
+	return strings.Join(append(fields, cmd.Env...), "\x00")
+}
+
+// Copy of the group without any IP change, used to reload a previous configuration.
+func (g *reloadGroup) reverted() *reloadGroup {
+	reverted := *g
+	reverted.changes = changes.New[string]()
+	reverted.groupChanges = make(map[string]*changes.Changes[string])
+	for name := range g.groupChanges {
+		reverted.groupChanges[name] = changes.New[string]()
+	}
+	return &reverted
+}
+
+// The resource whose reload settings are used for the whole group.
+func (g *reloadGroup) leader() *resource.Resource {
+	return g.resources[0]
+}
+
+// Templates of the group resources, for logging purpose.
+func (g *reloadGroup) templates() string {
+	templates := make([]string, 0, len(g.resources))
+	for _, resource := range g.resources {
+		templates = append(templates, resource.Src)
+	}
+	return strings.Join(templates, ",")
+}
+
+// Run the reload command of a group, exporting IP changes in its environment.
+// Returns the exit code of the command and the time it took.
+func (r *Reconciler) reload(ctx context.Context, group *reloadGroup) (int, time.Duration, error) {
+	resource := group.leader()
+	if resource.ReloadSignal != "" {
+		return r.reloadSignal(group)
+	}
+
+	if resource.ReloadTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, resource.ReloadTimeout)
+		defer cancel()
+	}
+
+	if resource.ReloadUnit != "" {
+		return r.reloadUnit(ctx, group)
+	}
+
+	cmd := reloadCommand(resource)
+	changesFile, err := writeChangesFile(group)
+	if err != nil {
+		return -1, 0, err
+	}
+	defer os.Remove(changesFile)
+	cmd.Env = append(cmd.Env, group.env(changesFile)...)
+	cmd.Files = append(cmd.Files, changesFile)
+
+	// Log the IP_ADDED and IP_REMOVED environment variables
+	slog.Info("Executing reload command for resource",
+		"resource_template", group.templates(),
+		"cmd", resource.Command(),
+		"ip_added", strings.Join(sorted(group.changes.Added()), " "),
+		"ip_removed", strings.Join(sorted(group.changes.Removed()), " "))
+
+	stdout := &limitedBuffer{limit: reloadOutputLimit}
+	stderr := &limitedBuffer{limit: reloadOutputLimit}
+
+	start := r.config.Clock.Now()
+	exitCode, err := r.config.Runner.Run(ctx, cmd, stdout, stderr)
+	duration := r.config.Clock.Now().Sub(start)
+
+	logOutput(group, "stdout", stdout)
+	logOutput(group, "stderr", stderr)
+
+	if ctx.Err() == context.DeadlineExceeded {
+		err = fmt.Errorf("reload command timed out after %s: %w", resource.ReloadTimeout, err)
+	}
+	return exitCode, duration, err
+}
+
+// Log the output of the reload command of a group, if any.
+func logOutput(group *reloadGroup, stream string, output *limitedBuffer) {
+	if output.Len() == 0 {
+		return
+	}
+	slog.Info("Reload command output",
+		"resource_template", group.templates(),
+		"stream", stream,
+		"output", strings.TrimRight(output.String(), "\n"),
+		"truncated", output.truncated)
+}
+
+// Send the reload signal of a group to its process, instead of running a command.
+func (r *Reconciler) reloadSignal(group *reloadGroup) (int, time.Duration, error) {
+	resource := group.leader()
+
+	sig, err := process.ParseSignal(resource.ReloadSignal)
+	if err != nil {
+		return -1, 0, err
+	}
+
+	start := r.config.Clock.Now()
+	pids, err := r.config.Runner.Signal(resource.PidFile, resource.ProcessName, sig)
+	duration := r.config.Clock.Now().Sub(start)
+
+	var stale *process.StalePidFileError
+	if errors.As(err, &stale) {
+		slog.Warn("Stale pid file, process is not running",
+			"resource_template", group.templates(),
+			"pid_file", stale.PidFile,
+			"pid", stale.Pid)
+	}
+	if err != nil {
+		return -1, duration, err
+	}
+
+	slog.Info("Sent reload signal",
+		"resource_template", group.templates(),
+		"signal", sig.String(),
+		"pids", pids)
+	return 0, duration, nil
+}
+
+// Ask systemd to reload or restart the unit of a group, instead of running a command,
+// and wait for the job to complete.
+func (r *Reconciler) reloadUnit(ctx context.Context, group *reloadGroup) (int, time.Duration, error) {
+	resource := group.leader()
+
+	start := r.config.Clock.Now()
+	err := r.config.Runner.RunUnit(ctx, resource.ReloadUnit, resource.UnitAction())
+	duration := r.config.Clock.Now().Sub(start)
+
+	var jobErr *systemd.JobError
+	if errors.As(err, &jobErr) {
+		slog.Warn("Unit job did not complete",
+			"resource_template", group.templates(),
+			"unit", jobErr.Unit,
+			"action", jobErr.Action,
+			"result", jobErr.Result)
+	}
+	if err != nil {
+		if ctx.Err() == context.DeadlineExceeded {
+			err = fmt.Errorf("unit job timed out after %s: %w", resource.ReloadTimeout, err)
+		}
+		return -1, duration, err
+	}
+
+	slog.Info("Unit job completed",
+		"resource_template", group.templates(),
+		"unit", resource.ReloadUnit,
+		"action", resource.UnitAction())
+	return 0, duration, nil
+}
+
+// Prepare the reload command of a resource, with its additional environment sorted by name.
+func reloadCommand(resource *resource.Resource) *Command {
+	cmd := &Command{
+		Argv:   resource.ReloadArgv,
+		Script: resource.ReloadCmd,
+		Dir:    resource.ReloadDir,
+		User:   resource.ReloadUser,
+	}
+
+	envNames := make([]string, 0, len(resource.ReloadEnv))
+	for name := range resource.ReloadEnv {
+		envNames = append(envNames, name)
+	}
+	sort.Strings(envNames)
+	for _, name := range envNames {
+		cmd.Env = append(cmd.Env, name+"="+resource.ReloadEnv[name])
+	}
+
+	return cmd
+}
+
+// Reload a group of resources, verify the result with their probes, and rollback
+// to the previous version of their dest files on failure when backups are enabled.
+func (r *Reconciler) apply(ctx context.Context, group *reloadGroup) *state.Reload {
+	status := &state.Reload{Time: r.config.Clock.Now()}
+
+	// A failed verify probe falls back to the reload command as well, which loads the whole
+	// configuration and rolls it back if the probe fails again
+	if !group.full && group.hasRuntime() {
+		err := updateRuntime(ctx, group)
+		if err == nil {
+			slog.Info("Backend servers updated through HAProxy Runtime API",
+				"resource_template", group.templates())
+
+			if err = r.verifyGroup(ctx, group); err == nil {
+				status.Runtime = true
+				status.Verified = group.hasVerify()
+				return status
+			}
+		}
+
+		slog.Warn("HAProxy Runtime API update failed, running reload command",
+			"resource_template", group.templates(),
+			"error", err)
+	}
+
+	exitCode, duration, err := r.reload(ctx, group)
+	status.ExitCode = exitCode
+	status.Duration = duration
+	if err != nil {
+		status.Error = err.Error()
+		slog.Warn("Reload command failed",
+			"resource_template", group.templates(),
+			"cmd", group.leader().Command(),
+			"exit_code", exitCode,
+			"duration", duration,
+			"error", err)
+	} else {
+		slog.Info("Reload command successful",
+			"resource_template", group.templates(),
+			"cmd", group.leader().Command(),
+			"exit_code", exitCode,
+			"duration", duration)
+
+		if err = r.verifyGroup(ctx, group); err != nil {
+			status.VerifyError = err.Error()
+		} else {
+			status.Verified = group.hasVerify()
+		}
+	}
+
+	if !status.Failed() || !group.hasBackups() {
+		return status
+	}
+
+	err = r.rollback(ctx, group)
+	if err != nil {
+		slog.Error("Rollback of managed resource failed",
+			"resource_template", group.templates(),
+			"error", err)
+		return status
+	}
+
+	status.RolledBack = true
+	slog.Info("Rollback of managed resource successful",
+		"resource_template", group.templates())
+	return status
+}
+
+// Tells whether all the resources of the group can be updated through the HAProxy Runtime API.
+func (g *reloadGroup) hasRuntime() bool {
+	for _, resource := range g.resources {
+		if resource.HAProxy == nil {
+			return false
+		}
+	}
+	return true
+}
+
+// Update the backends mapped to the changed lookables through the HAProxy Runtime API.
+// Fails when a changed lookable is not mapped to any backend, as its template needs a reload.
+func updateRuntime(ctx context.Context, group *reloadGroup) error {
+	for _, resource := range group.resources {
+		client := haproxy.New(resource.HAProxy)
+
+		for _, l := range resource.Lookables() {
+			name := l.String()
+			lookableChanges := group.groupChanges[name]
+			if len(lookableChanges.Added())+len(lookableChanges.Removed()) == 0 {
+				continue
+			}
+
+			backend, exists := resource.HAProxy.Backends[name]
+			if !exists {
+				return fmt.Errorf("no haproxy backend for changed group %s", name)
+			}
+
+			err := client.Sync(ctx, backend, group.current[name], resource.HAProxy.Port)
+			if err != nil {
+				return err
+			}
+
+			slog.Info("HAProxy backend synchronized",
+				"resource_template", resource.Src,
+				"group", name,
+				"backend", backend,
+				"ip_added", strings.Join(sorted(lookableChanges.Added()), " "),
+				"ip_removed", strings.Join(sorted(lookableChanges.Removed()), " "))
+		}
+	}
+	return nil
+}
+
+// Tells whether at least one resource of the group has a verify probe.
+func (g *reloadGroup) hasVerify() bool {
+	for _, resource := range g.resources {
+		if resource.Verify != nil {
+			return true
+		}
+	}
+	return false
+}
+
+// Tells whether at least one resource of the group keeps backups.
+func (g *reloadGroup) hasBackups() bool {
+	for _, resource := range g.resources {
+		if resource.Backups > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// Run the verify probes of the group resources and log their result.
+// Returns the first error met.
+func (r *Reconciler) verifyGroup(ctx context.Context, group *reloadGroup) error {
+	var firstErr error
+
+	for _, resource := range group.resources {
+		if resource.Verify == nil {
+			continue
+		}
+
+		err := resource.Verify.Run(ctx, r.config.Clock)
+		if err != nil {
+			slog.Warn("Verify probe failed",
+				"resource_template", resource.Src,
+				"probe", resource.Verify.String(),
+				"error", err)
+			if firstErr == nil {
+				firstErr = err
+			}
+		} else {
+			slog.Info("Verify probe successful",
+				"resource_template", resource.Src,
+				"probe", resource.Verify.String())
+		}
+	}
+
+	return firstErr
+}
+
+// Put back the previous version of the group resources dest files and reload them again,
+// so that the managed process gets back to its last known-good configuration.
+func (r *Reconciler) rollback(ctx context.Context, group *reloadGroup) error {
+	for _, resource := range group.resources {
+		if resource.Backups <= 0 {
+			continue
+		}
+		if err := r.backups.Restore(resource.Dest); err != nil {
+			return fmt.Errorf("%s: %w", resource.Dest, err)
+		}
+
+		slog.Warn("Restored previous version of managed resource",
+			"resource_template", resource.Src,
+			"dest", resource.Dest)
+	}
+
+	if _, _, err := r.reload(ctx, group.reverted()); err != nil {
+		return err
+	}
+	return r.verifyGroup(ctx, group)
+}
+
+// limitedBuffer keeps the first bytes written to it, up to its limit. The buffer is not
+// embedded, as io.Copy would bypass Write through its ReadFrom method.
+type limitedBuffer struct {
+	buf       bytes.Buffer
+	limit     int
+	truncated bool
+}
+
+// Write never fails so that the writing process is not interrupted, extra bytes are discarded.
+func (b *limitedBuffer) Write(p []byte) (int, error) {
+	if room := b.limit - b.buf.Len(); len(p) > room {
+		b.truncated = true
+		b.buf.Write(p[:max(room, 0)])
+	} else {
+		b.buf.Write(p)
+	}
+	return len(p), nil
+}
+
+func (b *limitedBuffer) Len() int {
+	return b.buf.Len()
+}
+
+func (b *limitedBuffer) String() string {
+	return b.buf.String()
+}
@@ -0,0 +1,143 @@
+package engine
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"os/user"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"github.com/AirVantage/overlord/pkg/process"
+	"github.com/AirVantage/overlord/pkg/systemd"
+)
+
+// Command is a reload command to run.
+type Command struct {
+	// Program and its arguments, run without shell
+	Argv []string
+	// Script run with bash -c when Argv is empty
+	Script string
+	Dir    string
+	// Variables added to the environment of overlord
+	Env []string
+	// Name or id of the user running the command, the current user when empty
+	User string
+	// Files read by the command, given to its user when set
+	Files []string
+}
+
+func (c *Command) String() string {
+	if len(c.Argv) > 0 {
+		return strings.Join(c.Argv, " ")
+	}
+	return c.Script
+}
+
+// Runner runs reload commands, sends reload signals and runs systemd unit jobs.
+type Runner interface {
+	// Run cmd until it exits or ctx expires, writing its output to stdout and stderr.
+	// Returns the exit code of the command.
+	Run(ctx context.Context, cmd *Command, stdout, stderr io.Writer) (int, error)
+	// Signal sends sig to the process of pidFile, or to all the processes named name
+	// when pidFile is empty. Returns the signaled pids.
+	Signal(pidFile, name string, sig syscall.Signal) ([]int, error)
+	// RunUnit runs the action job of a systemd unit until it completes or ctx expires.
+	RunUnit(ctx context.Context, unit, action string) error
+}
+
+// ExecRunner runs commands as child processes, each one in its own process group
+// so that the command and all its children are killed when ctx expires.
+// It signals the processes of the host, and runs unit jobs through the systemd D-Bus API.
+type ExecRunner struct{}
+
+func (ExecRunner) Run(ctx context.Context, cmd *Command, stdout, stderr io.Writer) (int, error) {
+	var c *exec.Cmd
+	if len(cmd.Argv) > 0 {
+		c = process.Command(ctx, cmd.Argv[0], cmd.Argv[1:]...)
+	} else {
+		c = process.Command(ctx, "bash", "-c", cmd.Script)
+	}
+
+	c.Dir = cmd.Dir
+	c.Env = append(os.Environ(), cmd.Env...)
+
+	if cmd.User != "" {
+		credential, err := lookupCredential(cmd.User)
+		if err != nil {
+			return -1, err
+		}
+		c.SysProcAttr.Credential = credential
+
+		for _, file := range cmd.Files {
+			if err := os.Chown(file, int(credential.Uid), int(credential.Gid)); err != nil {
+				return -1, err
+			}
+		}
+	}
+
+	c.Stdout = stdout
+	c.Stderr = stderr
+
+	if err := c.Start(); err != nil {
+		return -1, fmt.Errorf("unable to start reload command: %w", err)
+	}
+
+	err := process.Wait(ctx, c)
+	return c.ProcessState.ExitCode(), err
+}
+
+func (ExecRunner) Signal(pidFile, name string, sig syscall.Signal) ([]int, error) {
+	return process.Signal(pidFile, name, sig)
+}
+
+func (ExecRunner) RunUnit(ctx context.Context, unit, action string) error {
+	client, err := systemd.Connect(ctx)
+	if err != nil {
+		return fmt.Errorf("unable to connect to systemd: %w", err)
+	}
+	defer client.Close()
+	return client.Run(ctx, unit, action)
+}
+
+// Find the user and groups ids of a user name or id.
+func lookupCredential(name string) (*syscall.Credential, error) {
+	u, err := user.Lookup(name)
+	if err != nil {
+		var unknown user.UnknownUserError
+		if !errors.As(err, &unknown) {
+			return nil, err
+		}
+		if u, err = user.LookupId(name); err != nil {
+			return nil, err
+		}
+	}
+
+	uid, err := strconv.ParseUint(u.Uid, 10, 32)
+	if err != nil {
+		return nil, err
+	}
+	gid, err := strconv.ParseUint(u.Gid, 10, 32)
+	if err != nil {
+		return nil, err
+	}
+
+	credential := &syscall.Credential{Uid: uint32(uid), Gid: uint32(gid)}
+	groupIds, err := u.GroupIds()
+	if err != nil {
+		return nil, err
+	}
+	for _, groupId := range groupIds {
+		group, err := strconv.ParseUint(groupId, 10, 32)
+		if err != nil {
+			return nil, err
+		}
+		credential.Groups = append(credential.Groups, uint32(group))
+	}
+
+	return credential, nil
+}
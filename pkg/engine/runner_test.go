@@ -0,0 +1,237 @@
+package engine
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestExecRunner(t *testing.T) {
+	dir := t.TempDir()
+
+	// Private file like the changes file, in a directory the other users can enter
+	changes, err := os.CreateTemp("", "overlord-changes-*.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(changes.Name())
+	changes.WriteString("{}\n")
+	changes.Close()
+
+	cases := []struct {
+		cmd *Command
+		// Only run as root
+		root     bool
+		stdout   string
+		exitCode int
+		err      bool
+	}{
+		/* Script run with bash */
+		{
+			cmd:      &Command{Script: "echo $((1 + 2)); exit 3"},
+			stdout:   "3\n",
+			exitCode: 3,
+			err:      true,
+		},
+		/* Arguments given as is, without shell */
+		{
+			cmd:    &Command{Argv: []string{"echo", "$HOME", "a b"}},
+			stdout: "$HOME a b\n",
+		},
+		/* Working directory */
+		{
+			cmd:    &Command{Script: "pwd", Dir: dir},
+			stdout: dir + "\n",
+		},
+		/* Additional environment */
+		{
+			cmd:    &Command{Argv: []string{"printenv", "RELOAD_TEST"}, Env: []string{"RELOAD_TEST=value"}},
+			stdout: "value\n",
+		},
+		/* Other user */
+		{
+			cmd:    &Command{Script: "id -un", User: "nobody"},
+			root:   true,
+			stdout: "nobody\n",
+		},
+		/* Files given to the other user */
+		{
+			cmd:    &Command{Argv: []string{"cat", changes.Name()}, User: "nobody", Files: []string{changes.Name()}},
+			root:   true,
+			stdout: "{}\n",
+		},
+		/* Unknown user */
+		{
+			cmd:      &Command{Script: "true", User: "overlord-unknown-user"},
+			exitCode: -1,
+			err:      true,
+		},
+	}
+
+	for i, tt := range cases {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			if tt.root && os.Getuid() != 0 {
+				t.Skip("requires root")
+			}
+
+			var stdout, stderr bytes.Buffer
+			exitCode, err := ExecRunner{}.Run(context.Background(), tt.cmd, &stdout, &stderr)
+			if (err != nil) != tt.err {
+				t.Errorf("expect error %v, got %v", tt.err, err)
+			}
+			if exitCode != tt.exitCode {
+				t.Errorf("expect exit code %d, got %d", tt.exitCode, exitCode)
+			}
+			if stdout.String() != tt.stdout {
+				t.Errorf("expect %q, got %q (stderr %q)", tt.stdout, stdout.String(), stderr.String())
+			}
+		})
+	}
+}
+
+func TestExecRunnerTimeout(t *testing.T) {
+
+	cases := []string{
+		/* Command still running with its child */
+		"sleep 30 & echo $! > %s; sleep 30",
+		/* Child outliving bash, holding its output */
+		"sleep 30 & echo $! > %s",
+	}
+
+	for i, script := range cases {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			pidFile := filepath.Join(t.TempDir(), "child.pid")
+			ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+			defer cancel()
+
+			begin := time.Now()
+			var output bytes.Buffer
+			_, err := ExecRunner{}.Run(ctx, &Command{Script: strings.Replace(script, "%s", pidFile, 1)}, &output, &output)
+			if err == nil {
+				t.Errorf("expect timeout error, got %q", output.String())
+			}
+			if elapsed := time.Since(begin); elapsed > 5*time.Second {
+				t.Errorf("expect command killed, took %s", elapsed)
+			}
+
+			content, err := os.ReadFile(pidFile)
+			if err != nil {
+				t.Fatal(err)
+			}
+			pid, err := strconv.Atoi(strings.TrimSpace(string(content)))
+			if err != nil {
+				t.Fatal(err)
+			}
+			for deadline := time.Now().Add(5 * time.Second); alive(pid); time.Sleep(10 * time.Millisecond) {
+				if time.Now().After(deadline) {
+					t.Fatalf("expect child %d killed with the process group", pid)
+				}
+			}
+		})
+	}
+}
+
+// Tells whether a process is running, zombies waiting to be reaped are not.
+func alive(pid int) bool {
+	stat, err := os.ReadFile(filepath.Join("/proc", strconv.Itoa(pid), "stat"))
+	if err != nil {
+		return false
+	}
+	// The state follows the command name, which is in parentheses
+	fields := strings.Fields(string(stat[bytes.LastIndexByte(stat, ')')+1:]))
+	return len(fields) > 0 && fields[0] != "Z"
+}
+
+func TestLookupCredential(t *testing.T) {
+	nobody, err := user.Lookup("nobody")
+	if err != nil {
+		t.Skip("no nobody user")
+	}
+
+	cases := []struct {
+		name string
+		uid  string
+		err  bool
+	}{
+		/* User name */
+		{
+			name: "nobody",
+			uid:  nobody.Uid,
+		},
+		/* User id */
+		{
+			name: nobody.Uid,
+			uid:  nobody.Uid,
+		},
+		/* Unknown user */
+		{
+			name: "overlord-unknown-user",
+			err:  true,
+		},
+	}
+
+	for i, tt := range cases {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			credential, err := lookupCredential(tt.name)
+			if (err != nil) != tt.err {
+				t.Fatalf("expect error %v, got %v", tt.err, err)
+			}
+			if err == nil && strconv.FormatUint(uint64(credential.Uid), 10) != tt.uid {
+				t.Errorf("expect uid %s, got %d", tt.uid, credential.Uid)
+			}
+		})
+	}
+}
+
+func TestReloadOutput(t *testing.T) {
+
+	cases := []struct {
+		script    string
+		stdout    int
+		stderr    int
+		truncated bool
+	}{
+		/* Output kept whole */
+		{
+			script: "head -c 100 /dev/zero; head -c 10 /dev/zero >&2",
+			stdout: 100,
+			stderr: 10,
+		},
+		/* Output up to the limit */
+		{
+			script: "head -c 16384 /dev/zero",
+			stdout: reloadOutputLimit,
+		},
+		/* Output over the limit truncated, without interrupting the command */
+		{
+			script:    "head -c 100000 /dev/zero; echo done >&2",
+			stdout:    reloadOutputLimit,
+			stderr:    5,
+			truncated: true,
+		},
+	}
+
+	for i, tt := range cases {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			stdout := &limitedBuffer{limit: reloadOutputLimit}
+			stderr := &limitedBuffer{limit: reloadOutputLimit}
+
+			exitCode, err := ExecRunner{}.Run(context.Background(), &Command{Script: tt.script}, stdout, stderr)
+			if err != nil || exitCode != 0 {
+				t.Fatalf("expect success, got %d %v", exitCode, err)
+			}
+			if stdout.Len() != tt.stdout || stdout.truncated != tt.truncated {
+				t.Errorf("expect stdout of %d bytes truncated %v, got %d %v", tt.stdout, tt.truncated, stdout.Len(), stdout.truncated)
+			}
+			if stderr.Len() != tt.stderr || stderr.truncated {
+				t.Errorf("expect stderr of %d bytes, got %d %v", tt.stderr, stderr.Len(), stderr.truncated)
+			}
+		})
+	}
+}
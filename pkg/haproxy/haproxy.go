@@ -0,0 +1,285 @@
+package haproxy
+
+// HAProxy Runtime API client, to update backend servers without reloading HAProxy
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const defaultTimeout = 5 * time.Second
+
+// Server admin state flags, see HAProxy management guide.
+const (
+	adminForcedMaint     = 0x01
+	adminInheritedMaint  = 0x02
+	adminConfigMaint     = 0x04
+	adminResolutionMaint = 0x20
+	adminMaint           = adminForcedMaint | adminInheritedMaint | adminConfigMaint | adminResolutionMaint
+)
+
+// ErrCapacity is returned when a backend has not enough server slots for all the IPs.
+var ErrCapacity = errors.New("not enough server slots")
+
+// Responses of the Runtime API denoting a failed command.
+var errorPrefixes = []string{"No such", "Unknown", "Require", "Permission denied", "Invalid", "Can't", "Missing", "Unexpected"}
+
+// Runtime configures the HAProxy Runtime API of a resource.
+type Runtime struct {
+	// Path to the HAProxy admin socket
+	Socket string `toml:"socket"`
+	// Port set on servers, the current one is kept when 0
+	Port int `toml:"port"`
+	// Timeout of each command
+	Timeout time.Duration `toml:"timeout"`
+	// Backend name for each lookable name
+	Backends map[string]string `toml:"backends"`
+}
+
+// Server is a server slot of a backend.
+type Server struct {
+	Name       string
+	Addr       string
+	Port       int
+	AdminState int
+}
+
+// Enabled tells whether the server is not in maintenance.
+func (s *Server) Enabled() bool {
+	return s.AdminState&adminMaint == 0
+}
+
+// Client sends commands to the HAProxy Runtime API.
+type Client struct {
+	Socket  string
+	Timeout time.Duration
+}
+
+// New returns a pointer to a Client for the runtime configuration.
+func New(r *Runtime) *Client {
+	return &Client{Socket: r.Socket, Timeout: r.Timeout}
+}
+
+// Command sends one command and returns its response.
+func (c *Client) Command(ctx context.Context, command string) (string, error) {
+	timeout := c.Timeout
+	if timeout <= 0 {
+		timeout = defaultTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "unix", c.Socket)
+	if err != nil {
+		return "", err
+	}
+	defer conn.Close()
+
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+
+	if _, err := io.WriteString(conn, command+"\n"); err != nil {
+		return "", err
+	}
+
+	// HAProxy closes the connection after the response in non-interactive mode
+	response, err := io.ReadAll(conn)
+	if err != nil {
+		return "", err
+	}
+
+	output := strings.TrimSpace(string(response))
+	for _, prefix := range errorPrefixes {
+		if strings.HasPrefix(output, prefix) {
+			return "", fmt.Errorf("%s: %s", command, output)
+		}
+	}
+	return output, nil
+}
+
+// Servers lists the server slots of a backend.
+func (c *Client) Servers(ctx context.Context, backend string) ([]Server, error) {
+	output, err := c.Command(ctx, "show servers state "+backend)
+	if err != nil {
+		return nil, err
+	}
+	return parseServersState(output)
+}
+
+// Parse the output of "show servers state": a version line, a header line listing
+// the columns, and one line per server.
+func parseServersState(output string) ([]Server, error) {
+	var (
+		servers []Server
+		columns map[string]int
+		err     error
+	)
+
+	scanner := bufio.NewScanner(strings.NewReader(output))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+
+		if strings.HasPrefix(line, "#") {
+			columns = make(map[string]int)
+			for i, name := range strings.Fields(strings.TrimPrefix(line, "#")) {
+				columns[name] = i
+			}
+			continue
+		}
+
+		// version line
+		if columns == nil {
+			continue
+		}
+
+		fields := strings.Fields(line)
+		field := func(name string) string {
+			if i, exists := columns[name]; exists && i < len(fields) {
+				return fields[i]
+			}
+			return ""
+		}
+
+		server := Server{
+			Name: field("srv_name"),
+			Addr: field("srv_addr"),
+		}
+		if server.Name == "" {
+			return nil, fmt.Errorf("unexpected servers state line: %q", line)
+		}
+		if server.AdminState, err = strconv.Atoi(field("srv_admin_state")); err != nil {
+			return nil, fmt.Errorf("unexpected servers state line: %q", line)
+		}
+		if port := field("srv_port"); port != "" {
+			server.Port, _ = strconv.Atoi(port)
+		}
+		servers = append(servers, server)
+	}
+
+	return servers, scanner.Err()
+}
+
+// Sync makes the enabled servers of backend match ips: servers whose IP is gone are put
+// in maintenance, and new IPs are set on servers in maintenance before enabling them.
+// ErrCapacity is returned, without changing anything, when there are not enough slots.
+func (c *Client) Sync(ctx context.Context, backend string, ips []string, port int) error {
+	servers, err := c.Servers(ctx, backend)
+	if err != nil {
+		return err
+	}
+
+	plan, err := planSync(servers, ips)
+	if err != nil {
+		return fmt.Errorf("backend %s: %w", backend, err)
+	}
+
+	for _, server := range plan.disable {
+		if _, err := c.Command(ctx, fmt.Sprintf("set server %s/%s state maint", backend, server)); err != nil {
+			return err
+		}
+	}
+
+	for _, assign := range plan.enable {
+		command := fmt.Sprintf("set server %s/%s addr %s", backend, assign.server, assign.ip)
+		if port > 0 {
+			command += " port " + strconv.Itoa(port)
+		}
+		if _, err := c.Command(ctx, command); err != nil {
+			return err
+		}
+		if _, err := c.Command(ctx, fmt.Sprintf("set server %s/%s state ready", backend, assign.server)); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+type assignment struct {
+	server string
+	ip     string
+}
+
+// Commands needed to synchronize a backend.
+type syncPlan struct {
+	disable []string
+	enable  []assignment
+}
+
+// Compute the servers to disable, and the IPs to set on servers to enable.
+func planSync(servers []Server, ips []string) (*syncPlan, error) {
+	plan := &syncPlan{}
+
+	wanted := make(map[string]bool, len(ips))
+	for _, ip := range ips {
+		wanted[ip] = true
+	}
+
+	// Keep enabled servers already set to a wanted IP, others become free slots
+	var free []Server
+	for _, server := range servers {
+		if server.Enabled() {
+			if wanted[server.Addr] {
+				delete(wanted, server.Addr)
+				continue
+			}
+			plan.disable = append(plan.disable, server.Name)
+		}
+		free = append(free, server)
+	}
+
+	// Prefer slots previously used by the same IP
+	var missing []string
+	for _, ip := range ips {
+		if !wanted[ip] {
+			continue
+		}
+		delete(wanted, ip)
+		found := false
+		for i, server := range free {
+			if server.Addr == ip {
+				plan.enable = append(plan.enable, assignment{server: server.Name, ip: ip})
+				free = append(free[:i], free[i+1:]...)
+				found = true
+				break
+			}
+		}
+		if !found {
+			missing = append(missing, ip)
+		}
+	}
+
+	if len(missing) > len(free) {
+		return nil, fmt.Errorf("%w: %d missing", ErrCapacity, len(missing)-len(free))
+	}
+	for i, ip := range missing {
+		plan.enable = append(plan.enable, assignment{server: free[i].Name, ip: ip})
+	}
+
+	// Servers disabled then enabled again only need a new address
+	enabled := make(map[string]bool, len(plan.enable))
+	for _, assign := range plan.enable {
+		enabled[assign.server] = true
+	}
+	disable := plan.disable[:0]
+	for _, server := range plan.disable {
+		if !enabled[server] {
+			disable = append(disable, server)
+		}
+	}
+	plan.disable = disable
+
+	return plan, nil
+}
@@ -0,0 +1,120 @@
+package haproxy
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"strconv"
+	"testing"
+
+	"github.com/AirVantage/overlord/pkg/haproxy/haproxytest"
+)
+
+const serversState = `1
+# be_id be_name srv_id srv_name srv_addr srv_op_state srv_admin_state srv_uweight srv_iweight srv_time_since_last_change srv_check_status srv_check_result srv_check_health srv_check_state srv_agent_state bk_f_forced_id srv_f_forced_id srv_fqdn srv_port srvrecord
+3 be_app 1 srv1 10.0.0.1 2 0 1 1 10 6 3 4 6 0 0 0 - 80 -
+3 be_app 2 srv2 10.0.0.2 2 0 1 1 10 6 3 4 6 0 0 0 - 80 -
+3 be_app 3 srv3 0.0.0.0 0 5 1 1 10 1 0 0 14 0 0 0 - 80 -
+3 be_app 4 srv4 10.0.0.9 0 1 1 1 10 1 0 0 14 0 0 0 - 80 -
+`
+
+func TestParseServersState(t *testing.T) {
+	servers, err := parseServersState(serversState)
+	if err != nil {
+		t.Fatalf("expect no error, got %v", err)
+	}
+
+	expect := []Server{
+		{Name: "srv1", Addr: "10.0.0.1", Port: 80, AdminState: 0},
+		{Name: "srv2", Addr: "10.0.0.2", Port: 80, AdminState: 0},
+		{Name: "srv3", Addr: "0.0.0.0", Port: 80, AdminState: 5},
+		{Name: "srv4", Addr: "10.0.0.9", Port: 80, AdminState: 1},
+	}
+	if !reflect.DeepEqual(expect, servers) {
+		t.Errorf("expect %v, got %v", expect, servers)
+	}
+}
+
+func TestPlanSync(t *testing.T) {
+	servers, err := parseServersState(serversState)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		ips    []string
+		expect *syncPlan
+		err    error
+	}{
+		/* Nothing to do */
+		{
+			ips:    []string{"10.0.0.1", "10.0.0.2"},
+			expect: &syncPlan{},
+		},
+		/* One IP removed */
+		{
+			ips:    []string{"10.0.0.2"},
+			expect: &syncPlan{disable: []string{"srv1"}},
+		},
+		/* One IP added */
+		{
+			ips:    []string{"10.0.0.1", "10.0.0.2", "10.0.0.3"},
+			expect: &syncPlan{enable: []assignment{{server: "srv3", ip: "10.0.0.3"}}},
+		},
+		/* IP back in its previous slot */
+		{
+			ips:    []string{"10.0.0.1", "10.0.0.2", "10.0.0.9"},
+			expect: &syncPlan{enable: []assignment{{server: "srv4", ip: "10.0.0.9"}}},
+		},
+		/* One IP replaced */
+		{
+			ips:    []string{"10.0.0.1", "10.0.0.3"},
+			expect: &syncPlan{enable: []assignment{{server: "srv2", ip: "10.0.0.3"}}},
+		},
+		/* Not enough slots */
+		{
+			ips: []string{"10.0.0.1", "10.0.0.2", "10.0.0.3", "10.0.0.4", "10.0.0.5"},
+			err: ErrCapacity,
+		},
+	}
+
+	for i, tt := range cases {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			plan, err := planSync(servers, tt.ips)
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("expect error %v, got %v", tt.err, err)
+			}
+			if tt.err != nil {
+				return
+			}
+			if len(plan.disable) == 0 {
+				plan.disable = nil
+			}
+			if !reflect.DeepEqual(tt.expect, plan) {
+				t.Errorf("expect %+v, got %+v", tt.expect, plan)
+			}
+		})
+	}
+}
+
+func TestSync(t *testing.T) {
+	socket := haproxytest.NewSocket(t, map[string]string{"be_app": serversState})
+	client := &Client{Socket: socket.Path}
+
+	err := client.Sync(context.TODO(), "be_app", []string{"10.0.0.2", "10.0.0.3"}, 8080)
+	if err != nil {
+		t.Fatalf("expect no error, got %v", err)
+	}
+
+	expect := []string{
+		"set server be_app/srv1 addr 10.0.0.3 port 8080",
+		"set server be_app/srv1 state ready",
+	}
+	if commands := socket.Commands(); !reflect.DeepEqual(expect, commands) {
+		t.Errorf("expect %v, got %v", expect, commands)
+	}
+
+	if err := client.Sync(context.TODO(), "be_other", nil, 0); err == nil {
+		t.Errorf("expect error on unknown backend")
+	}
+}
@@ -0,0 +1,65 @@
+package haproxytest
+
+// Fake of the HAProxy admin socket
+
+import (
+	"bufio"
+	"net"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// Socket is a fake HAProxy admin socket, answering the servers state of its backends
+// and recording the commands setting servers.
+type Socket struct {
+	Path string
+
+	mu       sync.Mutex
+	commands []string
+}
+
+// NewSocket listens on a socket in a temporary directory of t until the end of the test.
+// states is the output of "show servers state" for each backend.
+func NewSocket(t *testing.T, states map[string]string) *Socket {
+	s := &Socket{Path: filepath.Join(t.TempDir(), "admin.sock")}
+	listener, err := net.Listen("unix", s.Path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			command, _ := bufio.NewReader(conn).ReadString('\n')
+			command = strings.TrimSpace(command)
+			backend, isShow := strings.CutPrefix(command, "show servers state ")
+			switch {
+			case isShow && states[backend] != "":
+				conn.Write([]byte(states[backend]))
+			case strings.HasPrefix(command, "set server "):
+				s.mu.Lock()
+				s.commands = append(s.commands, command)
+				s.mu.Unlock()
+			default:
+				conn.Write([]byte("Unknown command.\n"))
+			}
+			conn.Close()
+		}
+	}()
+	return s
+}
+
+// Commands returns the recorded commands and forgets about them.
+func (s *Socket) Commands() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	commands := s.commands
+	s.commands = nil
+	return commands
+}
@@ -0,0 +1,52 @@
+package inventory
+
+// Inventories of lookables read from fixture files, to render templates without looking anything up
+
+import (
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+
+	"github.com/AirVantage/overlord/pkg/lookable"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Inventory maps lookable names to their members, given either as an IP or with their port and metadata.
+type Inventory map[string][]lookable.Member
+
+// Extensions of the fixture files, in order of preference.
+var Extensions = []string{".yaml", ".yml", ".json"}
+
+// Parse the content of a fixture file, in YAML or JSON according to the extension of name.
+// Members are sorted, like the ones looked up.
+func Parse(name string, content []byte) (Inventory, error) {
+	var inventory Inventory
+
+	switch filepath.Ext(name) {
+	case ".json":
+		if err := json.Unmarshal(content, &inventory); err != nil {
+			return nil, fmt.Errorf("%s: %w", name, err)
+		}
+	case ".yaml", ".yml":
+		if err := yaml.Unmarshal(content, &inventory); err != nil {
+			return nil, fmt.Errorf("%s: %w", name, err)
+		}
+	default:
+		return nil, fmt.Errorf("%s: unknown fixture format, expect one of %v", name, Extensions)
+	}
+
+	for _, members := range inventory {
+		lookable.SortMembers(members)
+	}
+	return inventory, nil
+}
+
+// IPs returns the sorted IPs of each lookable, the data given to templates.
+func (i Inventory) IPs() map[string][]string {
+	ips := make(map[string][]string)
+	for name, members := range i {
+		ips[name] = lookable.IPs(members)
+	}
+	return ips
+}
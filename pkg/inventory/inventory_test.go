@@ -0,0 +1,72 @@
+package inventory
+
+import (
+	"reflect"
+	"strconv"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+
+	cases := []struct {
+		name    string
+		content string
+		expect  Inventory
+		err     bool
+	}{
+		/* YAML, IPs sorted */
+		{
+			name:    "inventory.yaml",
+			content: "web-asg:\n  - 10.0.0.2\n  - 10.0.0.1\ndb: []\n",
+			expect:  Inventory{"web-asg": {{IP: "10.0.0.1"}, {IP: "10.0.0.2"}}, "db": {}},
+		},
+		/* JSON, IPv6 */
+		{
+			name:    "inventory.json",
+			content: `{"web-asg": ["2001:db8::1"]}`,
+			expect:  Inventory{"web-asg": {{IP: "2001:db8::1"}}},
+		},
+		/* Members with port and metadata */
+		{
+			name:    "inventory.yaml",
+			content: "web-asg:\n  - ip: 10.0.0.1\n    port: 8080\n    meta:\n      zone: eu-west-1a\n",
+			expect:  Inventory{"web-asg": {{IP: "10.0.0.1", Port: 8080, Meta: map[string]string{"zone": "eu-west-1a"}}}},
+		},
+		/* Invalid IP */
+		{
+			name:    "inventory.yml",
+			content: "web-asg: [i-0123]\n",
+			err:     true,
+		},
+		/* Instances are not a list */
+		{
+			name:    "inventory.json",
+			content: `{"web-asg": "10.0.0.1"}`,
+			err:     true,
+		},
+		/* Unknown format */
+		{
+			name:    "inventory.toml",
+			content: `web-asg = ["10.0.0.1"]`,
+			err:     true,
+		},
+	}
+
+	for i, tt := range cases {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			inventory, err := Parse(tt.name, []byte(tt.content))
+			if tt.err {
+				if err == nil {
+					t.Errorf("expect an error, got %v", inventory)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(inventory, tt.expect) {
+				t.Errorf("expect %v, got %v", tt.expect, inventory)
+			}
+		})
+	}
+}
@@ -81,25 +81,10 @@ func (asg AutoScalingGroup) doLookupIPs(as ASGAPI, ec EC2API, ctx context.Contex
 			},
 		},
 	}
-	resp3, err := ec.DescribeInstances(ctx, params3)
-	if err != nil {
-		return nil, err
-	}
-
-	for _, reservation := range resp3.Reservations {
-		for _, instance := range reservation.Instances {
-			if ipv6 {
-				output = append(output, *instance.Ipv6Address)
-			} else {
-				output = append(output, *instance.PrivateIpAddress)
-			}
-		}
-	}
-
-	return output, nil
+	return describeInstancesIPs(ctx, ec, params3, ipv6)
 }
 
 // LookupIPs of all the instances in this AutoScalingGroup.
-func (asg AutoScalingGroup) LookupIPs(ctx context.Context, cfg aws.Config, ipv6 bool) ([]string, error) {
-	return asg.doLookupIPs(autoscaling.NewFromConfig(cfg), ec2.NewFromConfig(cfg), ctx, ipv6)
+func (asg AutoScalingGroup) LookupIPs(ctx context.Context, clients *Clients, ipv6 bool) ([]string, error) {
+	return asg.doLookupIPs(clients.ASG, clients.EC2, ctx, ipv6)
 }
@@ -2,8 +2,13 @@ package lookable
 
 import (
 	"context"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/service/autoscaling"
 	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	"github.com/aws/aws-sdk-go-v2/service/ecs"
+	"github.com/aws/aws-sdk-go-v2/service/elasticloadbalancingv2"
+	"github.com/aws/aws-sdk-go-v2/service/servicediscovery"
 )
 
 type EC2API interface {
@@ -14,3 +19,45 @@ type EC2API interface {
 type ASGAPI interface {
 	DescribeAutoScalingGroups(context.Context, *autoscaling.DescribeAutoScalingGroupsInput, ...func(*autoscaling.Options)) (*autoscaling.DescribeAutoScalingGroupsOutput, error)
 }
+
+type ELBV2API interface {
+	DescribeTargetGroups(context.Context, *elasticloadbalancingv2.DescribeTargetGroupsInput, ...func(*elasticloadbalancingv2.Options)) (*elasticloadbalancingv2.DescribeTargetGroupsOutput, error)
+	DescribeTargetHealth(context.Context, *elasticloadbalancingv2.DescribeTargetHealthInput, ...func(*elasticloadbalancingv2.Options)) (*elasticloadbalancingv2.DescribeTargetHealthOutput, error)
+}
+
+type ECSAPI interface {
+	ListTasks(context.Context, *ecs.ListTasksInput, ...func(*ecs.Options)) (*ecs.ListTasksOutput, error)
+	DescribeTasks(context.Context, *ecs.DescribeTasksInput, ...func(*ecs.Options)) (*ecs.DescribeTasksOutput, error)
+	DescribeTaskDefinition(context.Context, *ecs.DescribeTaskDefinitionInput, ...func(*ecs.Options)) (*ecs.DescribeTaskDefinitionOutput, error)
+}
+
+type CloudMapAPI interface {
+	DiscoverInstances(context.Context, *servicediscovery.DiscoverInstancesInput, ...func(*servicediscovery.Options)) (*servicediscovery.DiscoverInstancesOutput, error)
+}
+
+// Clients are the AWS API clients and DNS resolver used by lookables to find instances.
+type Clients struct {
+	EC2      EC2API
+	ASG      ASGAPI
+	ELBV2    ELBV2API
+	ECS      ECSAPI
+	CloudMap CloudMapAPI
+	// Optional, DNS lookables resolve without caching when nil
+	DNS *Resolver
+	// Optional, Kubernetes lookables load their configuration on each lookup when nil
+	Kubernetes *Kubernetes
+}
+
+// NewClients returns a pointer to Clients created from an AWS configuration.
+func NewClients(cfg aws.Config) *Clients {
+	return &Clients{
+		EC2:      ec2.NewFromConfig(cfg),
+		ASG:      autoscaling.NewFromConfig(cfg),
+		ELBV2:    elasticloadbalancingv2.NewFromConfig(cfg),
+		ECS:      ecs.NewFromConfig(cfg),
+		CloudMap: servicediscovery.NewFromConfig(cfg),
+		DNS:      NewResolver(),
+		// Kubernetes clients are created on first use, without AWS configuration
+		Kubernetes: NewKubernetes(),
+	}
+}
@@ -0,0 +1,109 @@
+package lookable
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/servicediscovery"
+	sdtypes "github.com/aws/aws-sdk-go-v2/service/servicediscovery/types"
+)
+
+// Attributes of the Cloud Map instances holding their IPs and port.
+const (
+	cloudMapIPv4 = "AWS_INSTANCE_IPV4"
+	cloudMapIPv6 = "AWS_INSTANCE_IPV6"
+	cloudMapPort = "AWS_INSTANCE_PORT"
+)
+
+// Maximum number of instances returned by DiscoverInstances.
+const discoverInstancesLimit = 1000
+
+// CloudMapService is a Lookable AWS Cloud Map service, whose members are its registered instances.
+type CloudMapService struct {
+	Namespace string
+	Service   string
+	// HEALTHY, UNHEALTHY, ALL or HEALTHY_OR_ELSE_ALL, HEALTHY by default
+	HealthStatus string `toml:"health_status"`
+}
+
+// String returns the namespace and service, e.g. internal/web.
+func (s CloudMapService) String() string {
+	return s.Namespace + "/" + s.Service
+}
+
+// Validate the Cloud Map service configuration.
+func (s CloudMapService) Validate() error {
+	if s.Namespace == "" || s.Service == "" {
+		return errors.New("cloud map service requires a namespace and a service")
+	}
+	if s.HealthStatus != "" && !slices.Contains(sdtypes.HealthStatusFilter("").Values(), sdtypes.HealthStatusFilter(s.HealthStatus)) {
+		return fmt.Errorf("unsupported health status %s of cloud map service %s", s.HealthStatus, s)
+	}
+	return nil
+}
+
+// LookupMembers of the instances of the service with the configured health status. Members have the
+// port of the AWS_INSTANCE_PORT attribute, and all the attributes of their instance in their metadata,
+// along with its instance_id and health_status. Instances without an IP, like CNAME ones, are ignored.
+func (s CloudMapService) doLookupMembers(api CloudMapAPI, ctx context.Context, ipv6 bool) ([]Member, error) {
+	healthStatus := sdtypes.HealthStatusFilterHealthy
+	if s.HealthStatus != "" {
+		healthStatus = sdtypes.HealthStatusFilter(s.HealthStatus)
+	}
+
+	resp, err := api.DiscoverInstances(ctx, &servicediscovery.DiscoverInstancesInput{
+		NamespaceName: aws.String(s.Namespace),
+		ServiceName:   aws.String(s.Service),
+		HealthStatus:  healthStatus,
+		MaxResults:    aws.Int32(discoverInstancesLimit),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	ipAttribute := cloudMapIPv4
+	if ipv6 {
+		ipAttribute = cloudMapIPv6
+	}
+
+	members := []Member{}
+	for _, instance := range resp.Instances {
+		ip := instance.Attributes[ipAttribute]
+		if ip == "" {
+			continue
+		}
+
+		member := Member{IP: ip, Meta: map[string]string{
+			"instance_id":   aws.ToString(instance.InstanceId),
+			"health_status": string(instance.HealthStatus),
+		}}
+		for name, value := range instance.Attributes {
+			member.Meta[name] = value
+		}
+		if port, ok := instance.Attributes[cloudMapPort]; ok {
+			if member.Port, err = strconv.Atoi(port); err != nil {
+				return nil, fmt.Errorf("invalid port %s of cloud map instance %s", port, aws.ToString(instance.InstanceId))
+			}
+		}
+		members = append(members, member)
+	}
+	return members, nil
+}
+
+// LookupMembers of the instances of the service with the configured health status.
+func (s CloudMapService) LookupMembers(ctx context.Context, clients *Clients, ipv6 bool) ([]Member, error) {
+	return s.doLookupMembers(clients.CloudMap, ctx, ipv6)
+}
+
+// Implement public interface
+func (s CloudMapService) LookupIPs(ctx context.Context, clients *Clients, ipv6 bool) ([]string, error) {
+	members, err := s.LookupMembers(ctx, clients, ipv6)
+	if err != nil {
+		return nil, err
+	}
+	return IPs(members), nil
+}
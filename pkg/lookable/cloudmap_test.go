@@ -0,0 +1,99 @@
+package lookable
+
+import (
+	"context"
+	"reflect"
+	"strconv"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/servicediscovery"
+	sdtypes "github.com/aws/aws-sdk-go-v2/service/servicediscovery/types"
+)
+
+func TestCloudMapServiceLookupMembers(t *testing.T) {
+	instances := []sdtypes.HttpInstanceSummary{
+		{
+			InstanceId:   aws.String("web-1"),
+			HealthStatus: sdtypes.HealthStatusHealthy,
+			Attributes:   map[string]string{"AWS_INSTANCE_IPV4": "10.0.0.1", "AWS_INSTANCE_IPV6": "2001:db8::1", "AWS_INSTANCE_PORT": "8080", "AVAILABILITY_ZONE": "eu-west-1a", "version": "2"},
+		},
+		{
+			InstanceId:   aws.String("web-2"),
+			HealthStatus: sdtypes.HealthStatusHealthy,
+			Attributes:   map[string]string{"AWS_INSTANCE_IPV4": "10.0.0.2"},
+		},
+		{
+			InstanceId:   aws.String("web-3"),
+			HealthStatus: sdtypes.HealthStatusHealthy,
+			Attributes:   map[string]string{"AWS_INSTANCE_CNAME": "web-3.example.com"},
+		},
+	}
+
+	cases := []struct {
+		service      CloudMapService
+		ipv6         bool
+		healthStatus sdtypes.HealthStatusFilter
+		instances    []sdtypes.HttpInstanceSummary
+		expect       []Member
+		err          bool
+	}{
+		/* Healthy instances by default, without CNAME ones */
+		{
+			service:      CloudMapService{Namespace: "internal", Service: "web"},
+			healthStatus: sdtypes.HealthStatusFilterHealthy,
+			instances:    instances,
+			expect: []Member{
+				{IP: "10.0.0.1", Port: 8080, Meta: map[string]string{"instance_id": "web-1", "health_status": "HEALTHY", "AWS_INSTANCE_IPV4": "10.0.0.1", "AWS_INSTANCE_IPV6": "2001:db8::1", "AWS_INSTANCE_PORT": "8080", "AVAILABILITY_ZONE": "eu-west-1a", "version": "2"}},
+				{IP: "10.0.0.2", Meta: map[string]string{"instance_id": "web-2", "health_status": "HEALTHY", "AWS_INSTANCE_IPV4": "10.0.0.2"}},
+			},
+		},
+		/* All instances, in IPv6 */
+		{
+			service:      CloudMapService{Namespace: "internal", Service: "web", HealthStatus: "ALL"},
+			ipv6:         true,
+			healthStatus: sdtypes.HealthStatusFilterAll,
+			instances:    instances,
+			expect: []Member{
+				{IP: "2001:db8::1", Port: 8080, Meta: map[string]string{"instance_id": "web-1", "health_status": "HEALTHY", "AWS_INSTANCE_IPV4": "10.0.0.1", "AWS_INSTANCE_IPV6": "2001:db8::1", "AWS_INSTANCE_PORT": "8080", "AVAILABILITY_ZONE": "eu-west-1a", "version": "2"}},
+			},
+		},
+		/* Invalid port */
+		{
+			service:      CloudMapService{Namespace: "internal", Service: "web"},
+			healthStatus: sdtypes.HealthStatusFilterHealthy,
+			instances:    []sdtypes.HttpInstanceSummary{{InstanceId: aws.String("web-4"), Attributes: map[string]string{"AWS_INSTANCE_IPV4": "10.0.0.4", "AWS_INSTANCE_PORT": "http"}}},
+			err:          true,
+		},
+	}
+
+	for i, tt := range cases {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			api := &MockCloudMapAPI{
+				DiscoverInstancesMethod: func(ctx context.Context, params *servicediscovery.DiscoverInstancesInput, optFns ...func(*servicediscovery.Options)) (*servicediscovery.DiscoverInstancesOutput, error) {
+					if *params.NamespaceName != "internal" || *params.ServiceName != "web" {
+						t.Errorf("expect internal/web, got %s/%s", *params.NamespaceName, *params.ServiceName)
+					}
+					if params.HealthStatus != tt.healthStatus {
+						t.Errorf("expect health status %s, got %s", tt.healthStatus, params.HealthStatus)
+					}
+					return &servicediscovery.DiscoverInstancesOutput{Instances: tt.instances}, nil
+				},
+			}
+
+			members, err := tt.service.doLookupMembers(api, context.Background(), tt.ipv6)
+			if tt.err {
+				if err == nil {
+					t.Errorf("expect an error, got %v", members)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(members, tt.expect) {
+				t.Errorf("expect %v, got %v", tt.expect, members)
+			}
+		})
+	}
+}
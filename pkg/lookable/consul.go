@@ -0,0 +1,228 @@
+package lookable
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/AirVantage/overlord/pkg/backoff"
+)
+
+// Default address of the Consul HTTP API, when CONSUL_HTTP_ADDR is not set.
+const consulDefaultAddress = "http://127.0.0.1:8500"
+
+// Maximum duration of a Consul blocking query.
+const consulWait = 5 * time.Minute
+
+// Client of the Consul HTTP API, whose timeout leaves blocking queries the jitter Consul adds to their wait.
+var consulClient = &http.Client{Timeout: consulWait + consulWait/16 + 30*time.Second}
+
+// Health of the instances of a ConsulService.
+const (
+	ConsulHealthPassing = "passing"
+	ConsulHealthAny     = "any"
+)
+
+// ConsulService is a Lookable service of the Consul catalog, whose members are its instances.
+type ConsulService struct {
+	Service string
+	// Tags that the instances must all have
+	Tags       List
+	Datacenter string
+	// Instances whose checks are all passing, or any instance, passing by default
+	Health string
+	// URL of the Consul HTTP API, CONSUL_HTTP_ADDR or http://127.0.0.1:8500 by default.
+	// The ACL token is read from CONSUL_HTTP_TOKEN.
+	Address string
+}
+
+// String returns the service, prefixed by its tags and followed by its datacenter like in
+// Consul DNS names, e.g. v2.web@dc1.
+func (s ConsulService) String() string {
+	name := s.Service
+	if s.Tags != "" {
+		name = strings.Join(s.Tags.Values(), ".") + "." + name
+	}
+	if s.Datacenter != "" {
+		name += "@" + s.Datacenter
+	}
+	return name
+}
+
+// Validate the Consul service configuration.
+func (s ConsulService) Validate() error {
+	if s.Service == "" {
+		return errors.New("consul requires a service")
+	}
+	if s.Health != "" && s.Health != ConsulHealthPassing && s.Health != ConsulHealthAny {
+		return fmt.Errorf("unsupported health %s of consul service %s", s.Health, s.Service)
+	}
+	return nil
+}
+
+// Entry of the Consul health API.
+type consulEntry struct {
+	Node struct {
+		Node       string
+		Address    string
+		Datacenter string
+	}
+	Service struct {
+		ID              string
+		Address         string
+		Port            int
+		Tags            []string
+		Meta            map[string]string
+		TaggedAddresses map[string]struct {
+			Address string
+			Port    int
+		}
+	}
+}
+
+// LookupMembers of the instances of the service. Members have the port of their instance, and its
+// node, service_id, datacenter, tags (comma separated) and service metadata in their metadata.
+// The address of an instance is the one of its service, or of its node, or its lan_ipv6 tagged
+// address in IPv6. Instances whose address is not an IP are ignored.
+func (s ConsulService) LookupMembers(ctx context.Context, clients *Clients, ipv6 bool) ([]Member, error) {
+	entries, _, err := s.query(ctx, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	members := []Member{}
+	for _, entry := range entries {
+		ip, port := entry.Service.Address, entry.Service.Port
+		if ip == "" {
+			ip = entry.Node.Address
+		}
+		if ipv6 {
+			tagged, exists := entry.Service.TaggedAddresses["lan_ipv6"]
+			if !exists {
+				continue
+			}
+			ip, port = tagged.Address, tagged.Port
+		}
+		if addr := net.ParseIP(ip); addr == nil || (addr.To4() == nil) != ipv6 {
+			continue
+		}
+
+		member := Member{IP: ip, Port: port, Meta: map[string]string{
+			"node":       entry.Node.Node,
+			"service_id": entry.Service.ID,
+			"datacenter": entry.Node.Datacenter,
+			"tags":       strings.Join(entry.Service.Tags, ","),
+		}}
+		for name, value := range entry.Service.Meta {
+			member.Meta[name] = value
+		}
+		members = append(members, member)
+	}
+	return members, nil
+}
+
+// Implement public interface
+func (s ConsulService) LookupIPs(ctx context.Context, clients *Clients, ipv6 bool) ([]string, error) {
+	members, err := s.LookupMembers(ctx, clients, ipv6)
+	if err != nil {
+		return nil, err
+	}
+	return IPs(members), nil
+}
+
+// Watch the service with blocking queries, calling changed whenever the Consul index of the
+// service changes.
+func (s ConsulService) Watch(ctx context.Context, clients *Clients, changed func()) error {
+	_, index, err := s.query(ctx, 0)
+	if err != nil {
+		return err
+	}
+
+	reconnect := &backoff.Backoff{Min: watchBackoffMin, Max: watchBackoffMax}
+	for {
+		_, newIndex, err := s.query(ctx, max(index, 1))
+		if err != nil {
+			return err
+		}
+		// The index may go backwards, e.g. after a Consul snapshot restore
+		if newIndex != index {
+			index = newIndex
+			changed()
+			reconnect.Reset()
+			continue
+		}
+		// Queries returning without change, on wait timeout or early, are spaced out
+		if err := sleep(ctx, reconnect.Next()); err != nil {
+			return err
+		}
+	}
+}
+
+// Query the health API for the instances of the service, blocking until the Consul index
+// is greater than index when not 0. Returns the entries and the new index.
+func (s ConsulService) query(ctx context.Context, index uint64) ([]consulEntry, uint64, error) {
+	address := s.Address
+	if address == "" {
+		address = os.Getenv("CONSUL_HTTP_ADDR")
+	}
+	if address == "" {
+		address = consulDefaultAddress
+	}
+	if !strings.Contains(address, "://") {
+		address = "http://" + address
+	}
+
+	params := url.Values{}
+	for _, tag := range s.Tags.Values() {
+		params.Add("tag", tag)
+	}
+	if s.Datacenter != "" {
+		params.Set("dc", s.Datacenter)
+	}
+	if s.Health != ConsulHealthAny {
+		params.Set("passing", "1")
+	}
+	if index > 0 {
+		params.Set("index", strconv.FormatUint(index, 10))
+		params.Set("wait", consulWait.String())
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, strings.TrimSuffix(address, "/")+"/v1/health/service/"+url.PathEscape(s.Service)+"?"+params.Encode(), nil)
+	if err != nil {
+		return nil, 0, err
+	}
+	if token := os.Getenv("CONSUL_HTTP_TOKEN"); token != "" {
+		req.Header.Set("X-Consul-Token", token)
+	}
+
+	resp, err := consulClient.Do(req)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, 0, fmt.Errorf("consul service %s: %s", s.Service, resp.Status)
+	}
+
+	var entries []consulEntry
+	if err := json.NewDecoder(resp.Body).Decode(&entries); err != nil {
+		return nil, 0, fmt.Errorf("consul service %s: %w", s.Service, err)
+	}
+	newIndex, err := strconv.ParseUint(resp.Header.Get("X-Consul-Index"), 10, 64)
+	if err != nil {
+		return nil, 0, fmt.Errorf("consul service %s: invalid index: %w", s.Service, err)
+	}
+
+	// Consul does not guarantee the order of the entries
+	slices.SortFunc(entries, func(a, b consulEntry) int { return strings.Compare(a.Service.ID, b.Service.ID) })
+	return entries, newIndex, nil
+}
@@ -0,0 +1,213 @@
+package lookable
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+// Fake Consul HTTP API answering the health queries of a service, blocking queries
+// waiting until the entries are set again.
+type consulServer struct {
+	url     string
+	entries string
+	index   uint64
+	// Closed when the entries are set
+	updated chan struct{}
+	queries []string
+	// Protects all the fields above
+	mu sync.Mutex
+}
+
+func newConsulServer(t *testing.T) *consulServer {
+	s := &consulServer{entries: "[]", index: 1, updated: make(chan struct{})}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		s.mu.Lock()
+		s.queries = append(s.queries, req.URL.RequestURI())
+		updated := s.updated
+		index := s.index
+		s.mu.Unlock()
+
+		if wait, _ := strconv.ParseUint(req.URL.Query().Get("index"), 10, 64); wait >= index {
+			select {
+			case <-updated:
+			case <-req.Context().Done():
+				return
+			}
+		}
+
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		w.Header().Set("X-Consul-Index", strconv.FormatUint(s.index, 10))
+		w.Write([]byte(s.entries))
+	}))
+	t.Cleanup(server.Close)
+	s.url = server.URL
+	return s
+}
+
+// Set the entries and the index of the service, unblocking the blocking queries.
+func (s *consulServer) set(index uint64, entries string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.index = index
+	s.entries = entries
+	close(s.updated)
+	s.updated = make(chan struct{})
+}
+
+func (s *consulServer) queried() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	queries := s.queries
+	s.queries = nil
+	return queries
+}
+
+func TestConsulLookupMembers(t *testing.T) {
+	s := newConsulServer(t)
+	s.set(1, `[
+		{"Node": {"Node": "node-2", "Address": "10.0.0.2", "Datacenter": "dc1"},
+		 "Service": {"ID": "web-2", "Port": 8080, "Tags": ["v2"]}},
+		{"Node": {"Node": "node-1", "Address": "10.0.0.1", "Datacenter": "dc1"},
+		 "Service": {"ID": "web-1", "Address": "10.0.1.1", "Port": 8081, "Tags": ["v1", "v2"], "Meta": {"weight": "10"},
+		  "TaggedAddresses": {"lan_ipv6": {"Address": "2001:db8::1", "Port": 8082}}}},
+		{"Node": {"Node": "node-3", "Address": "node-3.example", "Datacenter": "dc1"},
+		 "Service": {"ID": "web-3", "Port": 8080}}
+	]`)
+
+	cases := []struct {
+		service ConsulService
+		ipv6    bool
+		expect  []Member
+		query   string
+	}{
+		/* Passing instances, at the address of their service or node */
+		{
+			service: ConsulService{Service: "web"},
+			expect: []Member{
+				{IP: "10.0.1.1", Port: 8081, Meta: map[string]string{"node": "node-1", "service_id": "web-1", "datacenter": "dc1", "tags": "v1,v2", "weight": "10"}},
+				{IP: "10.0.0.2", Port: 8080, Meta: map[string]string{"node": "node-2", "service_id": "web-2", "datacenter": "dc1", "tags": "v2"}},
+			},
+			query: "/v1/health/service/web?passing=1",
+		},
+		/* Tags, datacenter and any health */
+		{
+			service: ConsulService{Service: "web", Tags: NewList("v2", "v1"), Datacenter: "dc1", Health: ConsulHealthAny},
+			expect: []Member{
+				{IP: "10.0.1.1", Port: 8081, Meta: map[string]string{"node": "node-1", "service_id": "web-1", "datacenter": "dc1", "tags": "v1,v2", "weight": "10"}},
+				{IP: "10.0.0.2", Port: 8080, Meta: map[string]string{"node": "node-2", "service_id": "web-2", "datacenter": "dc1", "tags": "v2"}},
+			},
+			query: "/v1/health/service/web?dc=dc1&tag=v1&tag=v2",
+		},
+		/* IPv6 tagged addresses */
+		{
+			service: ConsulService{Service: "web"},
+			ipv6:    true,
+			expect: []Member{
+				{IP: "2001:db8::1", Port: 8082, Meta: map[string]string{"node": "node-1", "service_id": "web-1", "datacenter": "dc1", "tags": "v1,v2", "weight": "10"}},
+			},
+			query: "/v1/health/service/web?passing=1",
+		},
+	}
+
+	for i, tt := range cases {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			tt.service.Address = s.url
+			members, err := tt.service.LookupMembers(context.Background(), &Clients{}, tt.ipv6)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(members, tt.expect) {
+				t.Errorf("expect %v, got %v", tt.expect, members)
+			}
+			if queries := s.queried(); !Equal(queries, []string{tt.query}) {
+				t.Errorf("expect query %v, got %v", tt.query, queries)
+			}
+		})
+	}
+}
+
+func TestConsulString(t *testing.T) {
+	cases := []struct {
+		service ConsulService
+		expect  string
+	}{
+		/* Service only */
+		{service: ConsulService{Service: "web"}, expect: "web"},
+		/* Tags and datacenter like in Consul DNS names */
+		{service: ConsulService{Service: "web", Tags: NewList("v2", "blue"), Datacenter: "dc1"}, expect: "blue.v2.web@dc1"},
+	}
+
+	for i, tt := range cases {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			if name := tt.service.String(); name != tt.expect {
+				t.Errorf("expect %v, got %v", tt.expect, name)
+			}
+		})
+	}
+}
+
+func TestConsulWatch(t *testing.T) {
+	s := newConsulServer(t)
+	s.set(5, "[]")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	changed := make(chan struct{}, 10)
+	done := make(chan error)
+	go func() {
+		done <- ConsulService{Service: "web", Address: s.url}.Watch(ctx, &Clients{}, func() { changed <- struct{}{} })
+	}()
+
+	// Wait for the blocking query on the index of the first query
+	for queries := 0; queries < 2; queries += len(s.queried()) {
+		time.Sleep(time.Millisecond)
+	}
+	select {
+	case <-changed:
+		t.Fatal("expect no change before the index changed")
+	default:
+	}
+
+	cases := []struct {
+		index   uint64
+		changed bool
+	}{
+		/* Same index, e.g. on wait timeout */
+		{index: 5},
+		/* Newer index */
+		{index: 7, changed: true},
+		/* Index going backwards */
+		{index: 2, changed: true},
+	}
+
+	for i, tt := range cases {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			s.set(tt.index, `[{"Node": {"Address": "10.0.0.1"}, "Service": {"ID": "web-1"}}]`)
+			// Wait for the next blocking query
+			for len(s.queried()) == 0 {
+				time.Sleep(time.Millisecond)
+			}
+			select {
+			case <-changed:
+				if !tt.changed {
+					t.Error("expect no change")
+				}
+			default:
+				if tt.changed {
+					t.Error("expect a change")
+				}
+			}
+		})
+	}
+
+	cancel()
+	if err := <-done; err == nil {
+		t.Error("expect an error once cancelled")
+	}
+}
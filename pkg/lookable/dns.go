@@ -0,0 +1,379 @@
+package lookable
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"net"
+	"net/netip"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// Types of DNS records looked up.
+const (
+	DNSTypeA    = "A"
+	DNSTypeAAAA = "AAAA"
+	DNSTypeSRV  = "SRV"
+)
+
+// Default timeout of a DNS query.
+const dnsTimeout = 5 * time.Second
+
+// DNS is a Lookable DNS name, whose members are the addresses of its A or AAAA records,
+// or the targets of its SRV records.
+type DNS struct {
+	Name string
+	// A, AAAA or SRV, A or AAAA according to the IP version by default
+	Type string
+	// Address of the DNS server, host[:port], the first nameserver of /etc/resolv.conf by default
+	Resolver string
+}
+
+func (d DNS) String() string {
+	return d.Name
+}
+
+// Validate the DNS lookable configuration.
+func (d DNS) Validate() error {
+	if d.Name == "" {
+		return errors.New("dns requires a name")
+	}
+	switch d.Type {
+	case "", DNSTypeA, DNSTypeAAAA, DNSTypeSRV:
+		return nil
+	default:
+		return fmt.Errorf("unsupported dns type %s of %s", d.Type, d.Name)
+	}
+}
+
+// LookupMembers resolves the records of the name. Members of SRV records have the port of their
+// record, its priority, weight and target in their metadata. A name which does not exist has no members.
+func (d DNS) LookupMembers(ctx context.Context, clients *Clients, ipv6 bool) ([]Member, error) {
+	var resolver *Resolver
+	if clients != nil {
+		resolver = clients.DNS
+	}
+	if resolver == nil {
+		resolver = NewResolver()
+	}
+
+	server := d.Resolver
+	if server == "" {
+		var err error
+		if server, err = defaultNameserver(); err != nil {
+			return nil, err
+		}
+	}
+	if _, _, err := net.SplitHostPort(server); err != nil {
+		server = net.JoinHostPort(server, "53")
+	}
+
+	addressType := DNSTypeA
+	if ipv6 {
+		addressType = DNSTypeAAAA
+	}
+	switch d.Type {
+	case DNSTypeSRV:
+		return resolver.lookupSRV(ctx, server, d.Name, addressType)
+	case "":
+		return resolver.lookup(ctx, server, d.Name, addressType)
+	default:
+		return resolver.lookup(ctx, server, d.Name, d.Type)
+	}
+}
+
+// Implement public interface
+func (d DNS) LookupIPs(ctx context.Context, clients *Clients, ipv6 bool) ([]string, error) {
+	members, err := d.LookupMembers(ctx, clients, ipv6)
+	if err != nil {
+		return nil, err
+	}
+	return IPs(members), nil
+}
+
+// Resolver sends the queries of DNS lookables, caching the answers for the TTL of their records.
+type Resolver struct {
+	// Now returns the current time, to expire the cache
+	Now     func() time.Time
+	Timeout time.Duration
+
+	mu    sync.Mutex
+	cache map[dnsQuestion]dnsAnswer
+}
+
+type dnsQuestion struct {
+	server, name, qtype string
+}
+
+type dnsAnswer struct {
+	members []Member
+	expires time.Time
+}
+
+// NewResolver returns a pointer to a Resolver with an empty cache.
+func NewResolver() *Resolver {
+	return &Resolver{
+		Now:     time.Now,
+		Timeout: dnsTimeout,
+		cache:   make(map[dnsQuestion]dnsAnswer),
+	}
+}
+
+// Members of the A or AAAA records of name.
+func (r *Resolver) lookup(ctx context.Context, server, name, qtype string) ([]Member, error) {
+	return r.cached(server, name, qtype, func() ([]Member, uint32, error) {
+		msg, err := r.exchange(ctx, server, name, qtype)
+		if err != nil || msg == nil {
+			return nil, 0, err
+		}
+		members, ttl := addresses(msg.Answers, "")
+		return members, ttl, nil
+	})
+}
+
+// Members of the SRV records of name, resolving their targets to A or AAAA records when the
+// server did not give their addresses.
+func (r *Resolver) lookupSRV(ctx context.Context, server, name, addressType string) ([]Member, error) {
+	return r.cached(server, name, DNSTypeSRV, func() ([]Member, uint32, error) {
+		msg, err := r.exchange(ctx, server, name, DNSTypeSRV)
+		if err != nil || msg == nil {
+			return nil, 0, err
+		}
+
+		members := []Member{}
+		ttl := uint32(0)
+		first := true
+		minTTL := func(t uint32) {
+			if first || t < ttl {
+				ttl = t
+			}
+			first = false
+		}
+
+		for _, answer := range msg.Answers {
+			srv, ok := answer.Body.(*dnsmessage.SRVResource)
+			if !ok {
+				continue
+			}
+			minTTL(answer.Header.TTL)
+
+			target := srv.Target.String()
+			if target == "." {
+				continue
+			}
+			targetMembers, targetTTL := addresses(msg.Additionals, target)
+			if len(targetMembers) == 0 {
+				if targetMembers, err = r.lookup(ctx, server, target, addressType); err != nil {
+					return nil, 0, err
+				}
+			} else {
+				minTTL(targetTTL)
+			}
+
+			for _, targetMember := range targetMembers {
+				if (addressType == DNSTypeAAAA) != strings.Contains(targetMember.IP, ":") {
+					continue
+				}
+				members = append(members, Member{
+					IP:   targetMember.IP,
+					Port: int(srv.Port),
+					Meta: map[string]string{
+						"priority": strconv.Itoa(int(srv.Priority)),
+						"weight":   strconv.Itoa(int(srv.Weight)),
+						"target":   strings.TrimSuffix(target, "."),
+					},
+				})
+			}
+		}
+		return members, ttl, nil
+	})
+}
+
+// Returns the members of a cached answer, or the ones of lookup cached for their TTL.
+func (r *Resolver) cached(server, name, qtype string, lookup func() ([]Member, uint32, error)) ([]Member, error) {
+	question := dnsQuestion{server: server, name: fqdn(name), qtype: qtype}
+
+	r.mu.Lock()
+	answer, exists := r.cache[question]
+	r.mu.Unlock()
+	if exists && r.Now().Before(answer.expires) {
+		return append([]Member{}, answer.members...), nil
+	}
+
+	members, ttl, err := lookup()
+	if err != nil {
+		return nil, err
+	}
+	if members == nil {
+		members = []Member{}
+	}
+
+	r.mu.Lock()
+	if ttl > 0 {
+		r.cache[question] = dnsAnswer{members: members, expires: r.Now().Add(time.Duration(ttl) * time.Second)}
+	} else {
+		delete(r.cache, question)
+	}
+	r.mu.Unlock()
+	return append([]Member{}, members...), nil
+}
+
+// Members of the A and AAAA records of resources, of name when not empty, and their minimum TTL.
+func addresses(resources []dnsmessage.Resource, name string) ([]Member, uint32) {
+	var members []Member
+	var ttl uint32
+	for _, resource := range resources {
+		if name != "" && !strings.EqualFold(resource.Header.Name.String(), name) {
+			continue
+		}
+		var ip netip.Addr
+		switch body := resource.Body.(type) {
+		case *dnsmessage.AResource:
+			ip = netip.AddrFrom4(body.A)
+		case *dnsmessage.AAAAResource:
+			ip = netip.AddrFrom16(body.AAAA)
+		default:
+			continue
+		}
+		if len(members) == 0 || resource.Header.TTL < ttl {
+			ttl = resource.Header.TTL
+		}
+		members = append(members, Member{IP: ip.String()})
+	}
+	return members, ttl
+}
+
+// Send a query to server over UDP, then over TCP when the answer is truncated.
+// Returns a nil message when the name does not exist.
+func (r *Resolver) exchange(ctx context.Context, server, name, qtype string) (*dnsmessage.Message, error) {
+	var t dnsmessage.Type
+	switch qtype {
+	case DNSTypeA:
+		t = dnsmessage.TypeA
+	case DNSTypeAAAA:
+		t = dnsmessage.TypeAAAA
+	case DNSTypeSRV:
+		t = dnsmessage.TypeSRV
+	}
+	qname, err := dnsmessage.NewName(fqdn(name))
+	if err != nil {
+		return nil, err
+	}
+
+	query := dnsmessage.Message{
+		Header:    dnsmessage.Header{ID: uint16(rand.Uint32()), RecursionDesired: true},
+		Questions: []dnsmessage.Question{{Name: qname, Type: t, Class: dnsmessage.ClassINET}},
+	}
+	packed, err := query.Pack()
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, r.Timeout)
+	defer cancel()
+
+	msg, err := r.send(ctx, "udp", server, packed, query.ID)
+	if err == nil && msg.Truncated {
+		msg, err = r.send(ctx, "tcp", server, packed, query.ID)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("dns %s %s: %w", qtype, name, err)
+	}
+
+	switch msg.RCode {
+	case dnsmessage.RCodeSuccess:
+		return msg, nil
+	case dnsmessage.RCodeNameError:
+		return nil, nil
+	default:
+		return nil, fmt.Errorf("dns %s %s: %s", qtype, name, msg.RCode)
+	}
+}
+
+// Send a packed query to server and read its answer, length-prefixed over TCP.
+func (r *Resolver) send(ctx context.Context, network, server string, packed []byte, id uint16) (*dnsmessage.Message, error) {
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, network, server)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+
+	var answer []byte
+	if network == "tcp" {
+		if _, err := conn.Write(binary.BigEndian.AppendUint16(nil, uint16(len(packed)))); err != nil {
+			return nil, err
+		}
+		if _, err := conn.Write(packed); err != nil {
+			return nil, err
+		}
+		var length [2]byte
+		if _, err := io.ReadFull(conn, length[:]); err != nil {
+			return nil, err
+		}
+		answer = make([]byte, binary.BigEndian.Uint16(length[:]))
+		if _, err := io.ReadFull(conn, answer); err != nil {
+			return nil, err
+		}
+	} else {
+		if _, err := conn.Write(packed); err != nil {
+			return nil, err
+		}
+		answer = make([]byte, 65535)
+		n, err := conn.Read(answer)
+		if err != nil {
+			return nil, err
+		}
+		answer = answer[:n]
+	}
+
+	var msg dnsmessage.Message
+	if err := msg.Unpack(answer); err != nil {
+		return nil, err
+	}
+	if msg.ID != id || !msg.Response {
+		return nil, errors.New("unexpected answer")
+	}
+	return &msg, nil
+}
+
+// First nameserver of /etc/resolv.conf.
+func defaultNameserver() (string, error) {
+	file, err := os.Open("/etc/resolv.conf")
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) >= 2 && fields[0] == "nameserver" {
+			return net.JoinHostPort(fields[1], "53"), nil
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return "", err
+	}
+	return "", errors.New("no nameserver in /etc/resolv.conf")
+}
+
+func fqdn(name string) string {
+	if strings.HasSuffix(name, ".") {
+		return name
+	}
+	return name + "."
+}
@@ -0,0 +1,281 @@
+package lookable
+
+import (
+	"context"
+	"encoding/binary"
+	"io"
+	"net"
+	"net/netip"
+	"reflect"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// In-process DNS server answering over UDP and TCP from a fixed zone.
+type dnsServer struct {
+	addr string
+	// Records of each name and type
+	zone map[dnsmessage.Question][]dnsmessage.Resource
+	// Records added to the additional section of the answers
+	additionals map[dnsmessage.Question][]dnsmessage.Resource
+	// Names answered with SERVFAIL
+	failing map[string]bool
+	// Whether UDP answers are truncated
+	truncate bool
+	queries  []string
+	// Protects all the fields above
+	mu sync.Mutex
+}
+
+func newDNSServer(t *testing.T) *dnsServer {
+	s := &dnsServer{
+		zone:        make(map[dnsmessage.Question][]dnsmessage.Resource),
+		additionals: make(map[dnsmessage.Question][]dnsmessage.Resource),
+		failing:     make(map[string]bool),
+	}
+
+	packetConn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.addr = packetConn.LocalAddr().String()
+	listener, err := net.Listen("tcp", s.addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		packetConn.Close()
+		listener.Close()
+	})
+
+	go func() {
+		buf := make([]byte, 512)
+		for {
+			n, addr, err := packetConn.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			packetConn.WriteTo(s.answer(buf[:n], true), addr)
+		}
+	}()
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			var length [2]byte
+			if _, err := io.ReadFull(conn, length[:]); err == nil {
+				query := make([]byte, binary.BigEndian.Uint16(length[:]))
+				if _, err := io.ReadFull(conn, query); err == nil {
+					answer := s.answer(query, false)
+					conn.Write(append(binary.BigEndian.AppendUint16(nil, uint16(len(answer))), answer...))
+				}
+			}
+			conn.Close()
+		}
+	}()
+	return s
+}
+
+func question(name string, t dnsmessage.Type) dnsmessage.Question {
+	return dnsmessage.Question{Name: dnsmessage.MustNewName(name), Type: t, Class: dnsmessage.ClassINET}
+}
+
+func (s *dnsServer) add(name string, ttl uint32, body dnsmessage.ResourceBody) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var q dnsmessage.Question
+	switch body.(type) {
+	case *dnsmessage.AResource:
+		q = question(name, dnsmessage.TypeA)
+	case *dnsmessage.AAAAResource:
+		q = question(name, dnsmessage.TypeAAAA)
+	case *dnsmessage.SRVResource:
+		q = question(name, dnsmessage.TypeSRV)
+	}
+	s.zone[q] = append(s.zone[q], dnsmessage.Resource{
+		Header: dnsmessage.ResourceHeader{Name: q.Name, Type: q.Type, Class: q.Class, TTL: ttl},
+		Body:   body,
+	})
+}
+
+func (s *dnsServer) queried() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	queries := s.queries
+	s.queries = nil
+	return queries
+}
+
+// Answer a packed query, truncated when received over UDP and truncate is set.
+func (s *dnsServer) answer(packed []byte, udp bool) []byte {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var query dnsmessage.Message
+	if err := query.Unpack(packed); err != nil || len(query.Questions) != 1 {
+		return nil
+	}
+	q := query.Questions[0]
+	s.queries = append(s.queries, q.Type.String()+" "+q.Name.String())
+
+	answer := dnsmessage.Message{
+		Header:    dnsmessage.Header{ID: query.ID, Response: true, Authoritative: true},
+		Questions: query.Questions,
+	}
+	switch {
+	case s.failing[q.Name.String()]:
+		answer.RCode = dnsmessage.RCodeServerFailure
+	case udp && s.truncate:
+		answer.Truncated = true
+	case len(s.zone[q]) == 0:
+		answer.RCode = dnsmessage.RCodeNameError
+	default:
+		answer.Answers = s.zone[q]
+		answer.Additionals = s.additionals[q]
+	}
+	packed, _ = answer.Pack()
+	return packed
+}
+
+func TestDNSLookupMembers(t *testing.T) {
+	s := newDNSServer(t)
+	s.add("web.example.", 60, &dnsmessage.AResource{A: [4]byte{10, 0, 0, 2}})
+	s.add("web.example.", 30, &dnsmessage.AResource{A: [4]byte{10, 0, 0, 1}})
+	s.add("web.example.", 60, &dnsmessage.AAAAResource{AAAA: netip.MustParseAddr("2001:db8::1").As16()})
+	s.add("_http._tcp.web.example.", 60, &dnsmessage.SRVResource{Priority: 1, Weight: 10, Port: 8080, Target: dnsmessage.MustNewName("web-1.example.")})
+	s.add("_http._tcp.web.example.", 60, &dnsmessage.SRVResource{Priority: 2, Weight: 20, Port: 8081, Target: dnsmessage.MustNewName("web-2.example.")})
+	s.add("web-2.example.", 60, &dnsmessage.AResource{A: [4]byte{10, 0, 0, 4}})
+	s.mu.Lock()
+	s.additionals[question("_http._tcp.web.example.", dnsmessage.TypeSRV)] = []dnsmessage.Resource{{
+		Header: dnsmessage.ResourceHeader{Name: dnsmessage.MustNewName("web-1.example."), Type: dnsmessage.TypeA, Class: dnsmessage.ClassINET, TTL: 60},
+		Body:   &dnsmessage.AResource{A: [4]byte{10, 0, 0, 3}},
+	}}
+	s.failing["broken.example."] = true
+	s.mu.Unlock()
+
+	cases := []struct {
+		dns      DNS
+		ipv6     bool
+		truncate bool
+		expect   []Member
+		queries  []string
+		err      bool
+	}{
+		/* A records */
+		{
+			dns:     DNS{Name: "web.example"},
+			expect:  []Member{{IP: "10.0.0.2"}, {IP: "10.0.0.1"}},
+			queries: []string{"TypeA web.example."},
+		},
+		/* AAAA records according to the IP version */
+		{
+			dns:     DNS{Name: "web.example"},
+			ipv6:    true,
+			expect:  []Member{{IP: "2001:db8::1"}},
+			queries: []string{"TypeAAAA web.example."},
+		},
+		/* Explicit A records over TCP, the UDP answer being truncated */
+		{
+			dns:      DNS{Name: "web.example.", Type: DNSTypeA},
+			ipv6:     true,
+			truncate: true,
+			expect:   []Member{{IP: "10.0.0.2"}, {IP: "10.0.0.1"}},
+			queries:  []string{"TypeA web.example.", "TypeA web.example."},
+		},
+		/* SRV records, the address of the second target being looked up */
+		{
+			dns: DNS{Name: "_http._tcp.web.example", Type: DNSTypeSRV},
+			expect: []Member{
+				{IP: "10.0.0.3", Port: 8080, Meta: map[string]string{"priority": "1", "weight": "10", "target": "web-1.example"}},
+				{IP: "10.0.0.4", Port: 8081, Meta: map[string]string{"priority": "2", "weight": "20", "target": "web-2.example"}},
+			},
+			queries: []string{"TypeSRV _http._tcp.web.example.", "TypeA web-2.example."},
+		},
+		/* Name which does not exist */
+		{
+			dns:     DNS{Name: "missing.example"},
+			expect:  []Member{},
+			queries: []string{"TypeA missing.example."},
+		},
+		/* Server failure */
+		{
+			dns:     DNS{Name: "broken.example"},
+			queries: []string{"TypeA broken.example."},
+			err:     true,
+		},
+	}
+
+	for i, tt := range cases {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			s.mu.Lock()
+			s.truncate = tt.truncate
+			s.mu.Unlock()
+			tt.dns.Resolver = s.addr
+
+			members, err := tt.dns.LookupMembers(context.Background(), &Clients{}, tt.ipv6)
+			if queries := s.queried(); !Equal(queries, tt.queries) {
+				t.Errorf("expect queries %v, got %v", tt.queries, queries)
+			}
+			if tt.err {
+				if err == nil {
+					t.Errorf("expect an error, got %v", members)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(members, tt.expect) {
+				t.Errorf("expect %v, got %v", tt.expect, members)
+			}
+		})
+	}
+}
+
+func TestDNSCache(t *testing.T) {
+	s := newDNSServer(t)
+	s.add("web.example.", 60, &dnsmessage.AResource{A: [4]byte{10, 0, 0, 1}})
+	s.add("web.example.", 30, &dnsmessage.AResource{A: [4]byte{10, 0, 0, 2}})
+	s.add("nocache.example.", 0, &dnsmessage.AResource{A: [4]byte{10, 0, 0, 3}})
+
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	resolver := NewResolver()
+	resolver.Now = func() time.Time { return now }
+	clients := &Clients{DNS: resolver}
+
+	cases := []struct {
+		name    string
+		elapsed time.Duration
+		queried bool
+	}{
+		/* First lookup */
+		{name: "web.example", queried: true},
+		/* Cached */
+		{name: "web.example", elapsed: 29 * time.Second},
+		/* Expired after the lowest TTL */
+		{name: "web.example", elapsed: time.Second, queried: true},
+		/* TTL of 0 */
+		{name: "nocache.example", queried: true},
+		/* Never cached */
+		{name: "nocache.example", queried: true},
+	}
+
+	for i, tt := range cases {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			now = now.Add(tt.elapsed)
+			if _, err := (DNS{Name: tt.name, Resolver: s.addr}).LookupMembers(context.Background(), clients, false); err != nil {
+				t.Fatal(err)
+			}
+			if queried := len(s.queried()) > 0; queried != tt.queried {
+				t.Errorf("expect queried %v, got %v", tt.queried, queried)
+			}
+		})
+	}
+}
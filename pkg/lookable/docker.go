@@ -0,0 +1,257 @@
+package lookable
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Default socket of the Docker daemon, when DOCKER_HOST is not set.
+const dockerDefaultSocket = "/var/run/docker.sock"
+
+// Events of the containers which change the running ones.
+var dockerEvents = []string{"start", "die", "pause", "unpause"}
+
+// DockerContainers is a Lookable of the running containers of the local Docker daemon having
+// all the given labels, whose members are the containers on their networks.
+type DockerContainers struct {
+	// Labels of the containers, as key=value or key only
+	Labels List
+	// Network of the members, all the networks of the containers by default
+	Network string
+	// Container port of the members, all the exposed ports by default
+	Port int
+	// Path of the daemon socket, the one of a unix:// DOCKER_HOST or /var/run/docker.sock by default
+	Socket string
+}
+
+// String returns the labels, comma separated, e.g. app=web,tier=front.
+func (d DockerContainers) String() string {
+	return string(d.Labels)
+}
+
+// Validate the Docker containers configuration.
+func (d DockerContainers) Validate() error {
+	if d.Labels == "" {
+		return errors.New("docker requires labels")
+	}
+	return nil
+}
+
+// Container of the Docker Engine API.
+type dockerContainer struct {
+	ID    string `json:"Id"`
+	Names []string
+	Image string
+	Ports []dockerPort
+
+	NetworkSettings struct {
+		Networks map[string]struct {
+			IPAddress         string
+			GlobalIPv6Address string
+		}
+	}
+}
+
+type dockerPort struct {
+	PrivatePort int
+	PublicPort  int
+	Type        string
+}
+
+// LookupMembers of the running containers having the labels, one per network and exposed port,
+// with the port published on the host, or the container port when not published. Their metadata
+// hold the container id (short), name, image and network, and the protocol and container_port of the port.
+// Containers without address, e.g. on the host network, are ignored.
+func (d DockerContainers) LookupMembers(ctx context.Context, clients *Clients, ipv6 bool) ([]Member, error) {
+	filters, err := d.filters(map[string][]string{"status": {"running"}})
+	if err != nil {
+		return nil, err
+	}
+	resp, err := d.get(ctx, "/containers/json?filters="+url.QueryEscape(filters))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var containers []dockerContainer
+	if err := json.NewDecoder(resp.Body).Decode(&containers); err != nil {
+		return nil, fmt.Errorf("docker %s: %w", d, err)
+	}
+
+	members := []Member{}
+	for _, container := range containers {
+		ports := dockerPorts(container.Ports, d.Port)
+		if d.Port != 0 && len(ports) == 0 {
+			continue
+		}
+
+		networks := make([]string, 0, len(container.NetworkSettings.Networks))
+		for network := range container.NetworkSettings.Networks {
+			networks = append(networks, network)
+		}
+		sort.Strings(networks)
+
+		for _, network := range networks {
+			if d.Network != "" && network != d.Network {
+				continue
+			}
+			ip := container.NetworkSettings.Networks[network].IPAddress
+			if ipv6 {
+				ip = container.NetworkSettings.Networks[network].GlobalIPv6Address
+			}
+			if ip == "" {
+				continue
+			}
+
+			meta := map[string]string{
+				"id":      container.ID[:min(12, len(container.ID))],
+				"image":   container.Image,
+				"network": network,
+			}
+			if len(container.Names) > 0 {
+				meta["name"] = strings.TrimPrefix(container.Names[0], "/")
+			}
+			if len(ports) == 0 {
+				members = append(members, Member{IP: ip, Meta: meta})
+			}
+			for _, port := range ports {
+				member := Member{IP: ip, Port: port.PrivatePort, Meta: map[string]string{"protocol": port.Type, "container_port": strconv.Itoa(port.PrivatePort)}}
+				if port.PublicPort != 0 {
+					member.Port = port.PublicPort
+				}
+				for name, value := range meta {
+					member.Meta[name] = value
+				}
+				members = append(members, member)
+			}
+		}
+	}
+	return members, nil
+}
+
+// Ports of a container, port only when not 0, once per container port and protocol.
+// The API lists the ports once per host address they are published on.
+func dockerPorts(ports []dockerPort, port int) []dockerPort {
+	var unique []dockerPort
+	index := make(map[dockerPort]int)
+	for _, p := range ports {
+		if port != 0 && p.PrivatePort != port {
+			continue
+		}
+		key := dockerPort{PrivatePort: p.PrivatePort, Type: p.Type}
+		if i, exists := index[key]; exists {
+			if unique[i].PublicPort == 0 {
+				unique[i].PublicPort = p.PublicPort
+			}
+			continue
+		}
+		index[key] = len(unique)
+		unique = append(unique, dockerPort{PrivatePort: p.PrivatePort, PublicPort: p.PublicPort, Type: p.Type})
+	}
+	return unique
+}
+
+// Implement public interface
+func (d DockerContainers) LookupIPs(ctx context.Context, clients *Clients, ipv6 bool) ([]string, error) {
+	members, err := d.LookupMembers(ctx, clients, ipv6)
+	if err != nil {
+		return nil, err
+	}
+	return IPs(members), nil
+}
+
+// Watch the events of the containers having the labels, calling changed whenever one is started,
+// stopped, paused or unpaused.
+func (d DockerContainers) Watch(ctx context.Context, clients *Clients, changed func()) error {
+	filters, err := d.filters(map[string][]string{"type": {"container"}, "event": dockerEvents})
+	if err != nil {
+		return err
+	}
+	resp, err := d.get(ctx, "/events?filters="+url.QueryEscape(filters))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	decoder := json.NewDecoder(resp.Body)
+	for {
+		var event json.RawMessage
+		if err := decoder.Decode(&event); err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			return fmt.Errorf("docker %s: events: %w", d, err)
+		}
+		changed()
+	}
+}
+
+// JSON filters of the labels, and of the given ones.
+func (d DockerContainers) filters(filters map[string][]string) (string, error) {
+	filters["label"] = d.Labels.Values()
+	encoded, err := json.Marshal(filters)
+	return string(encoded), err
+}
+
+// Send a GET request of path to the daemon, returning the response when its status is OK.
+func (d DockerContainers) get(ctx context.Context, path string) (*http.Response, error) {
+	base, client, err := d.client()
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, base+path, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("docker %s: %w", d, err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		defer resp.Body.Close()
+		var status struct{ Message string }
+		json.NewDecoder(resp.Body).Decode(&status)
+		return nil, fmt.Errorf("docker %s: %s %s", d, resp.Status, status.Message)
+	}
+	return resp, nil
+}
+
+// Base URL and HTTP client of the daemon, over its unix socket or a tcp:// DOCKER_HOST.
+func (d DockerContainers) client() (string, *http.Client, error) {
+	socket := d.Socket
+	if socket == "" {
+		if host := os.Getenv("DOCKER_HOST"); host != "" {
+			scheme, address, _ := strings.Cut(host, "://")
+			switch scheme {
+			case "unix":
+				socket = address
+			case "tcp":
+				return "http://" + address, http.DefaultClient, nil
+			default:
+				return "", nil, fmt.Errorf("unsupported DOCKER_HOST %s", host)
+			}
+		}
+	}
+	if socket == "" {
+		socket = dockerDefaultSocket
+	}
+
+	// Connections are not kept, as the client is not reused
+	transport := &http.Transport{
+		DisableKeepAlives: true,
+		DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+			var dialer net.Dialer
+			return dialer.DialContext(ctx, "unix", socket)
+		},
+	}
+	return "http://docker", &http.Client{Transport: transport}, nil
+}
@@ -0,0 +1,166 @@
+package lookable
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+// Fake Docker daemon listening on a unix socket, streaming the events sent to it.
+type dockerServer struct {
+	socket     string
+	containers string
+	events     chan string
+	queries    []string
+	// Protects the queries
+	mu sync.Mutex
+}
+
+func newDockerServer(t *testing.T) *dockerServer {
+	s := &dockerServer{socket: filepath.Join(t.TempDir(), "docker.sock"), containers: "[]", events: make(chan string)}
+	listener, err := net.Listen("unix", s.socket)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		s.mu.Lock()
+		s.queries = append(s.queries, req.URL.Path+" "+req.URL.Query().Get("filters"))
+		s.mu.Unlock()
+
+		switch req.URL.Path {
+		case "/containers/json":
+			w.Write([]byte(s.containers))
+		case "/events":
+			w.(http.Flusher).Flush()
+			for {
+				select {
+				case event := <-s.events:
+					// An empty event stands for the daemon stopping
+					if event == "" {
+						return
+					}
+					w.Write([]byte(event + "\n"))
+					w.(http.Flusher).Flush()
+				case <-req.Context().Done():
+					return
+				}
+			}
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	server.Listener = listener
+	server.Start()
+	t.Cleanup(server.Close)
+	return s
+}
+
+func (s *dockerServer) queried() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	queries := s.queries
+	s.queries = nil
+	return queries
+}
+
+func TestDockerLookupMembers(t *testing.T) {
+	s := newDockerServer(t)
+	s.containers = `[
+		{"Id": "0123456789abcdef", "Names": ["/web-1"], "Image": "web:2",
+		 "Ports": [{"IP": "0.0.0.0", "PrivatePort": 8080, "PublicPort": 32768, "Type": "tcp"}, {"IP": "::", "PrivatePort": 8080, "PublicPort": 32768, "Type": "tcp"}, {"PrivatePort": 9090, "Type": "tcp"}],
+		 "NetworkSettings": {"Networks": {"front": {"IPAddress": "172.18.0.2", "GlobalIPv6Address": "2001:db8::2"}, "back": {"IPAddress": "172.19.0.2"}}}},
+		{"Id": "fedcba9876543210", "Names": ["/web-2"], "Image": "web:2",
+		 "NetworkSettings": {"Networks": {"front": {"IPAddress": "172.18.0.3"}}}},
+		{"Id": "host", "Names": ["/web-host"], "Image": "web:2",
+		 "NetworkSettings": {"Networks": {"host": {}}}}
+	]`
+
+	web1 := func(ip, network string, port int, containerPort string) Member {
+		return Member{IP: ip, Port: port, Meta: map[string]string{"id": "0123456789ab", "name": "web-1", "image": "web:2", "network": network, "protocol": "tcp", "container_port": containerPort}}
+	}
+
+	cases := []struct {
+		docker DockerContainers
+		ipv6   bool
+		expect []Member
+	}{
+		/* Each network and port, published ports being preferred and merged when published on several addresses */
+		{
+			docker: DockerContainers{Labels: NewList("app=web")},
+			expect: []Member{
+				web1("172.19.0.2", "back", 32768, "8080"),
+				web1("172.19.0.2", "back", 9090, "9090"),
+				web1("172.18.0.2", "front", 32768, "8080"),
+				web1("172.18.0.2", "front", 9090, "9090"),
+				{IP: "172.18.0.3", Meta: map[string]string{"id": "fedcba987654", "name": "web-2", "image": "web:2", "network": "front"}},
+			},
+		},
+		/* Network and port */
+		{
+			docker: DockerContainers{Labels: NewList("app=web"), Network: "front", Port: 8080},
+			expect: []Member{
+				web1("172.18.0.2", "front", 32768, "8080"),
+			},
+		},
+		/* IPv6 addresses */
+		{
+			docker: DockerContainers{Labels: NewList("app=web"), Port: 9090},
+			ipv6:   true,
+			expect: []Member{
+				web1("2001:db8::2", "front", 9090, "9090"),
+			},
+		},
+	}
+
+	for i, tt := range cases {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			tt.docker.Socket = s.socket
+			members, err := tt.docker.LookupMembers(context.Background(), &Clients{}, tt.ipv6)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(members, tt.expect) {
+				t.Errorf("expect %v, got %v", tt.expect, members)
+			}
+			queries := s.queried()
+			if expect := []string{`/containers/json {"label":["app=web"],"status":["running"]}`}; !Equal(queries, expect) {
+				t.Errorf("expect %v, got %v", expect, queries)
+			}
+		})
+	}
+}
+
+func TestDockerWatch(t *testing.T) {
+	s := newDockerServer(t)
+
+	changed := make(chan struct{}, 10)
+	done := make(chan error)
+	go func() {
+		done <- DockerContainers{Labels: NewList("app=web", "tier"), Socket: s.socket}.Watch(context.Background(), &Clients{}, func() { changed <- struct{}{} })
+	}()
+
+	s.events <- `{"Type": "container", "Action": "start", "Actor": {"ID": "0123456789abcdef"}}`
+	select {
+	case <-changed:
+	case <-time.After(5 * time.Second):
+		t.Fatal("expect a change")
+	}
+	queries := s.queried()
+	if expect := []string{`/events {"event":["start","die","pause","unpause"],"label":["app=web","tier"],"type":["container"]}`}; !Equal(queries, expect) {
+		t.Errorf("expect %v, got %v", expect, queries)
+	}
+
+	// The watch fails when the daemon stops
+	s.events <- ""
+	if err := <-done; err == nil {
+		t.Error("expect an error")
+	}
+}
@@ -0,0 +1,184 @@
+package lookable
+
+import (
+	"context"
+	"errors"
+	"path"
+	"sort"
+	"strconv"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ecs"
+	ecstypes "github.com/aws/aws-sdk-go-v2/service/ecs/types"
+)
+
+// Maximum number of tasks described per DescribeTasks call.
+const describeTasksLimit = 100
+
+// ECSService is a Lookable ECS service, or task family, using the awsvpc network mode.
+// Its members are the containers of its running tasks.
+type ECSService struct {
+	// Cluster of the tasks, default when empty
+	Cluster string
+	// Either the name of the service, or the family of the tasks
+	Service string
+	Family  string
+	// Container whose ports are used, all the containers when empty
+	Container string
+}
+
+// String returns the cluster and service, or task family, e.g. prod/web.
+func (s ECSService) String() string {
+	name := s.Service
+	if name == "" {
+		name = s.Family
+	}
+	cluster := s.Cluster
+	if cluster == "" {
+		cluster = "default"
+	}
+	return cluster + "/" + name
+}
+
+// Validate the ECS service configuration.
+func (s ECSService) Validate() error {
+	if (s.Service == "") == (s.Family == "") {
+		return errors.New("ecs service requires either a service or a family")
+	}
+	return nil
+}
+
+// LookupMembers of the containers of the running tasks, one per container port of their task definition.
+// Members have the task id, container name, task definition, availability zone and status, running
+// or draining for the tasks being stopped, in their metadata. Tasks without an IP yet are ignored.
+func (s ECSService) doLookupMembers(api ECSAPI, ctx context.Context, ipv6 bool) ([]Member, error) {
+	var taskArns []string
+	for _, desiredStatus := range []ecstypes.DesiredStatus{ecstypes.DesiredStatusRunning, ecstypes.DesiredStatusStopped} {
+		params := &ecs.ListTasksInput{DesiredStatus: desiredStatus}
+		if s.Cluster != "" {
+			params.Cluster = aws.String(s.Cluster)
+		}
+		if s.Service != "" {
+			params.ServiceName = aws.String(s.Service)
+		} else {
+			params.Family = aws.String(s.Family)
+		}
+
+		paginator := ecs.NewListTasksPaginator(api, params)
+		for paginator.HasMorePages() {
+			page, err := paginator.NextPage(ctx)
+			if err != nil {
+				return nil, err
+			}
+			taskArns = append(taskArns, page.TaskArns...)
+		}
+	}
+
+	var tasks []ecstypes.Task
+	for start := 0; start < len(taskArns); start += describeTasksLimit {
+		end := min(start+describeTasksLimit, len(taskArns))
+		params := &ecs.DescribeTasksInput{Tasks: taskArns[start:end]}
+		if s.Cluster != "" {
+			params.Cluster = aws.String(s.Cluster)
+		}
+		resp, err := api.DescribeTasks(ctx, params)
+		if err != nil {
+			return nil, err
+		}
+		tasks = append(tasks, resp.Tasks...)
+	}
+	sort.Slice(tasks, func(i, j int) bool { return aws.ToString(tasks[i].TaskArn) < aws.ToString(tasks[j].TaskArn) })
+
+	// Container ports of each task definition
+	definitions := make(map[string]*ecstypes.TaskDefinition)
+	members := []Member{}
+	for _, task := range tasks {
+		// Stopped tasks are kept by ECS for a while after they stopped
+		if aws.ToString(task.LastStatus) != string(ecstypes.DesiredStatusRunning) {
+			continue
+		}
+		status := "running"
+		if aws.ToString(task.DesiredStatus) == string(ecstypes.DesiredStatusStopped) {
+			status = "draining"
+		}
+
+		definitionArn := aws.ToString(task.TaskDefinitionArn)
+		definition, exists := definitions[definitionArn]
+		if !exists {
+			resp, err := api.DescribeTaskDefinition(ctx, &ecs.DescribeTaskDefinitionInput{TaskDefinition: task.TaskDefinitionArn})
+			if err != nil {
+				return nil, err
+			}
+			definition = resp.TaskDefinition
+			definitions[definitionArn] = definition
+		}
+
+		for _, container := range task.Containers {
+			name := aws.ToString(container.Name)
+			if s.Container != "" && name != s.Container {
+				continue
+			}
+
+			var ip string
+			for _, networkInterface := range container.NetworkInterfaces {
+				if ipv6 && networkInterface.Ipv6Address != nil {
+					ip = *networkInterface.Ipv6Address
+				} else if !ipv6 && networkInterface.PrivateIpv4Address != nil {
+					ip = *networkInterface.PrivateIpv4Address
+				}
+			}
+			if ip == "" {
+				continue
+			}
+
+			meta := map[string]string{
+				"task":            path.Base(aws.ToString(task.TaskArn)),
+				"container":       name,
+				"task_definition": aws.ToString(definition.Family) + ":" + strconv.Itoa(int(definition.Revision)),
+				"status":          status,
+			}
+			if task.AvailabilityZone != nil {
+				meta["availability_zone"] = *task.AvailabilityZone
+			}
+
+			ports := containerPorts(definition, name)
+			if len(ports) == 0 {
+				members = append(members, Member{IP: ip, Meta: meta})
+			}
+			for _, port := range ports {
+				members = append(members, Member{IP: ip, Port: port, Meta: meta})
+			}
+		}
+	}
+	return members, nil
+}
+
+// Container ports of the mappings of a container of the task definition.
+func containerPorts(definition *ecstypes.TaskDefinition, container string) []int {
+	var ports []int
+	for _, containerDefinition := range definition.ContainerDefinitions {
+		if aws.ToString(containerDefinition.Name) != container {
+			continue
+		}
+		for _, mapping := range containerDefinition.PortMappings {
+			if mapping.ContainerPort != nil {
+				ports = append(ports, int(*mapping.ContainerPort))
+			}
+		}
+	}
+	return ports
+}
+
+// LookupMembers of the containers of the running tasks.
+func (s ECSService) LookupMembers(ctx context.Context, clients *Clients, ipv6 bool) ([]Member, error) {
+	return s.doLookupMembers(clients.ECS, ctx, ipv6)
+}
+
+// Implement public interface
+func (s ECSService) LookupIPs(ctx context.Context, clients *Clients, ipv6 bool) ([]string, error) {
+	members, err := s.LookupMembers(ctx, clients, ipv6)
+	if err != nil {
+		return nil, err
+	}
+	return IPs(members), nil
+}
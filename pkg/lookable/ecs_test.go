@@ -0,0 +1,129 @@
+package lookable
+
+import (
+	"context"
+	"reflect"
+	"strconv"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ecs"
+	ecstypes "github.com/aws/aws-sdk-go-v2/service/ecs/types"
+)
+
+const taskDefinitionARN = "arn:aws:ecs:eu-west-1:123456789012:task-definition/web:3"
+
+func task(id, ip, lastStatus, desiredStatus string) ecstypes.Task {
+	var networkInterfaces []ecstypes.NetworkInterface
+	if ip != "" {
+		networkInterfaces = []ecstypes.NetworkInterface{{PrivateIpv4Address: aws.String(ip), Ipv6Address: aws.String("2001:db8::" + id)}}
+	}
+	return ecstypes.Task{
+		TaskArn:           aws.String("arn:aws:ecs:eu-west-1:123456789012:task/prod/" + id),
+		TaskDefinitionArn: aws.String(taskDefinitionARN),
+		LastStatus:        aws.String(lastStatus),
+		DesiredStatus:     aws.String(desiredStatus),
+		AvailabilityZone:  aws.String("eu-west-1a"),
+		Containers: []ecstypes.Container{
+			{Name: aws.String("app"), NetworkInterfaces: networkInterfaces},
+			{Name: aws.String("envoy"), NetworkInterfaces: networkInterfaces},
+		},
+	}
+}
+
+func TestECSServiceLookupMembers(t *testing.T) {
+	tasks := map[ecstypes.DesiredStatus][]ecstypes.Task{
+		ecstypes.DesiredStatusRunning: {
+			task("1", "10.0.0.1", "RUNNING", "RUNNING"),
+			task("2", "", "PROVISIONING", "RUNNING"),
+		},
+		ecstypes.DesiredStatusStopped: {
+			task("3", "10.0.0.3", "RUNNING", "STOPPED"),
+			task("4", "10.0.0.4", "STOPPED", "STOPPED"),
+		},
+	}
+	meta := func(id, container, status string) map[string]string {
+		return map[string]string{"task": id, "container": container, "task_definition": "web:3", "status": status, "availability_zone": "eu-west-1a"}
+	}
+
+	cases := []struct {
+		service ECSService
+		ipv6    bool
+		expect  []Member
+	}{
+		/* Running and draining tasks of a service, all containers */
+		{
+			service: ECSService{Cluster: "prod", Service: "web"},
+			expect: []Member{
+				{IP: "10.0.0.1", Port: 8080, Meta: meta("1", "app", "running")},
+				{IP: "10.0.0.1", Port: 8443, Meta: meta("1", "app", "running")},
+				{IP: "10.0.0.1", Meta: meta("1", "envoy", "running")},
+				{IP: "10.0.0.3", Port: 8080, Meta: meta("3", "app", "draining")},
+				{IP: "10.0.0.3", Port: 8443, Meta: meta("3", "app", "draining")},
+				{IP: "10.0.0.3", Meta: meta("3", "envoy", "draining")},
+			},
+		},
+		/* Tasks of a family, one container, in IPv6 */
+		{
+			service: ECSService{Cluster: "prod", Family: "web", Container: "app"},
+			ipv6:    true,
+			expect: []Member{
+				{IP: "2001:db8::1", Port: 8080, Meta: meta("1", "app", "running")},
+				{IP: "2001:db8::1", Port: 8443, Meta: meta("1", "app", "running")},
+				{IP: "2001:db8::3", Port: 8080, Meta: meta("3", "app", "draining")},
+				{IP: "2001:db8::3", Port: 8443, Meta: meta("3", "app", "draining")},
+			},
+		},
+	}
+
+	api := &MockECSAPI{
+		ListTasksMethod: func(ctx context.Context, params *ecs.ListTasksInput, optFns ...func(*ecs.Options)) (*ecs.ListTasksOutput, error) {
+			if aws.ToString(params.Cluster) != "prod" || (aws.ToString(params.ServiceName) != "web" && aws.ToString(params.Family) != "web") {
+				t.Fatalf("unexpected ListTasks %v", params)
+			}
+			output := &ecs.ListTasksOutput{}
+			for _, task := range tasks[params.DesiredStatus] {
+				output.TaskArns = append(output.TaskArns, *task.TaskArn)
+			}
+			return output, nil
+		},
+		DescribeTasksMethod: func(ctx context.Context, params *ecs.DescribeTasksInput, optFns ...func(*ecs.Options)) (*ecs.DescribeTasksOutput, error) {
+			output := &ecs.DescribeTasksOutput{}
+			for _, arn := range params.Tasks {
+				for _, statusTasks := range tasks {
+					for _, task := range statusTasks {
+						if *task.TaskArn == arn {
+							output.Tasks = append(output.Tasks, task)
+						}
+					}
+				}
+			}
+			return output, nil
+		},
+		DescribeTaskDefinitionMethod: func(ctx context.Context, params *ecs.DescribeTaskDefinitionInput, optFns ...func(*ecs.Options)) (*ecs.DescribeTaskDefinitionOutput, error) {
+			if *params.TaskDefinition != taskDefinitionARN {
+				t.Fatalf("expect %s, got %s", taskDefinitionARN, *params.TaskDefinition)
+			}
+			return &ecs.DescribeTaskDefinitionOutput{TaskDefinition: &ecstypes.TaskDefinition{
+				Family:   aws.String("web"),
+				Revision: 3,
+				ContainerDefinitions: []ecstypes.ContainerDefinition{
+					{Name: aws.String("app"), PortMappings: []ecstypes.PortMapping{{ContainerPort: aws.Int32(8080)}, {ContainerPort: aws.Int32(8443)}}},
+					{Name: aws.String("envoy")},
+				},
+			}}, nil
+		},
+	}
+
+	for i, tt := range cases {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			members, err := tt.service.doLookupMembers(api, context.Background(), tt.ipv6)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(members, tt.expect) {
+				t.Errorf("expect %v, got %v", tt.expect, members)
+			}
+		})
+	}
+}
@@ -0,0 +1,62 @@
+package lookable
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// File is a Lookable local file, or directory of files, listing its members. It is read on every lookup.
+type File string
+
+func (f File) String() string {
+	return string(f)
+}
+
+// LookupMembers listed in the file, or in all the files of the directory, in lexical order.
+// Hidden files are ignored. Members are given as is, whatever the IP version.
+func (f File) LookupMembers(ctx context.Context, clients *Clients, ipv6 bool) ([]Member, error) {
+	info, err := os.Stat(f.String())
+	if err != nil {
+		return nil, err
+	}
+	if !info.IsDir() {
+		return readMembers(f.String())
+	}
+
+	entries, err := os.ReadDir(f.String())
+	if err != nil {
+		return nil, err
+	}
+
+	members := []Member{}
+	for _, entry := range entries {
+		if entry.IsDir() || strings.HasPrefix(entry.Name(), ".") {
+			continue
+		}
+		fileMembers, err := readMembers(filepath.Join(f.String(), entry.Name()))
+		if err != nil {
+			return nil, err
+		}
+		members = append(members, fileMembers...)
+	}
+	return members, nil
+}
+
+// Implement public interface
+func (f File) LookupIPs(ctx context.Context, clients *Clients, ipv6 bool) ([]string, error) {
+	members, err := f.LookupMembers(ctx, clients, ipv6)
+	if err != nil {
+		return nil, err
+	}
+	return IPs(members), nil
+}
+
+func readMembers(path string) ([]Member, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return ParseMembers(path, content)
+}
@@ -0,0 +1,116 @@
+package lookable
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"testing"
+)
+
+func TestFileLookupMembers(t *testing.T) {
+
+	cases := []struct {
+		files  map[string]string
+		path   string
+		expect []Member
+		err    bool
+	}{
+		/* Newline list with comments and blank lines */
+		{
+			files:  map[string]string{"web.txt": "# web servers\n10.0.0.2\n\n10.0.0.1 # canary\n"},
+			path:   "web.txt",
+			expect: []Member{{IP: "10.0.0.2"}, {IP: "10.0.0.1"}},
+		},
+		/* JSON with IPs and objects */
+		{
+			files:  map[string]string{"web.json": `["10.0.0.1", {"ip": "10.0.0.2", "port": 8080, "meta": {"zone": "a"}}]`},
+			path:   "web.json",
+			expect: []Member{{IP: "10.0.0.1"}, {IP: "10.0.0.2", Port: 8080, Meta: map[string]string{"zone": "a"}}},
+		},
+		/* YAML with IPs and mappings */
+		{
+			files:  map[string]string{"web.yaml": "- 2001:db8::1\n- ip: 10.0.0.2\n  port: 8080\n"},
+			path:   "web.yaml",
+			expect: []Member{{IP: "2001:db8::1"}, {IP: "10.0.0.2", Port: 8080}},
+		},
+		/* Directory, hidden files ignored */
+		{
+			files: map[string]string{
+				"web/b.txt":     "10.0.0.2\n",
+				"web/a.yml":     "- 10.0.0.1\n",
+				"web/.a.txt":    "10.0.0.9\n",
+				"web/sub/c.txt": "10.0.0.8\n",
+			},
+			path:   "web",
+			expect: []Member{{IP: "10.0.0.1"}, {IP: "10.0.0.2"}},
+		},
+		/* Invalid IP */
+		{
+			files: map[string]string{"web.txt": "10.0.0.1\nweb-1\n"},
+			path:  "web.txt",
+			err:   true,
+		},
+		/* Invalid port */
+		{
+			files: map[string]string{"web.json": `[{"ip": "10.0.0.1", "port": 70000}]`},
+			path:  "web.json",
+			err:   true,
+		},
+		/* Missing file */
+		{
+			path: "web.txt",
+			err:  true,
+		},
+	}
+
+	for i, tt := range cases {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			dir := t.TempDir()
+			for name, content := range tt.files {
+				path := filepath.Join(dir, name)
+				if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+					t.Fatal(err)
+				}
+				if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			members, err := File(filepath.Join(dir, tt.path)).LookupMembers(context.Background(), nil, false)
+			if tt.err {
+				if err == nil {
+					t.Errorf("expect an error, got %v", members)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(members, tt.expect) {
+				t.Errorf("expect %v, got %v", tt.expect, members)
+			}
+		})
+	}
+}
+
+func TestLookup(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "web.txt")
+	if err := os.WriteFile(path, []byte("10.0.0.2\n10.0.0.1\n10.0.0.2\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	members, err := Lookup(context.Background(), File(path), nil, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expect := []Member{{IP: "10.0.0.1"}, {IP: "10.0.0.2"}, {IP: "10.0.0.2"}}
+	if !reflect.DeepEqual(members, expect) {
+		t.Errorf("expect %v, got %v", expect, members)
+	}
+	if ips := IPs(members); !Equal(ips, []string{"10.0.0.1", "10.0.0.2"}) {
+		t.Errorf("expect %v, got %v", []string{"10.0.0.1", "10.0.0.2"}, ips)
+	}
+}
@@ -0,0 +1,32 @@
+package lookable
+
+import (
+	"context"
+
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+)
+
+// Returns the IPs of all the instances matching params, going through all the result pages.
+func describeInstancesIPs(ctx context.Context, api EC2API, params *ec2.DescribeInstancesInput, ipv6 bool) ([]string, error) {
+	var output []string
+
+	paginator := ec2.NewDescribeInstancesPaginator(api, params)
+	for paginator.HasMorePages() {
+		resp, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, reservation := range resp.Reservations {
+			for _, instance := range reservation.Instances {
+				if ipv6 {
+					output = append(output, *instance.Ipv6Address)
+				} else {
+					output = append(output, *instance.PrivateIpAddress)
+				}
+			}
+		}
+	}
+
+	return output, nil
+}
@@ -0,0 +1,375 @@
+package lookable
+
+// Access to the Kubernetes API, configured from a kubeconfig file or from within a pod
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/AirVantage/overlord/pkg/process"
+	"gopkg.in/yaml.v3"
+)
+
+// Files of the service account mounted in pods
+const (
+	serviceAccountToken = "/var/run/secrets/kubernetes.io/serviceaccount/token"
+	serviceAccountCA    = "/var/run/secrets/kubernetes.io/serviceaccount/ca.crt"
+)
+
+// Kubernetes keeps the clients of the Kubernetes API of each kubeconfig and context,
+// and the tokens of their credential plugins.
+type Kubernetes struct {
+	// Now returns the current time, to expire the tokens
+	Now func() time.Time
+
+	mu      sync.Mutex
+	clients map[kubeTarget]*kubeClient
+}
+
+type kubeTarget struct {
+	kubeconfig, context string
+}
+
+// NewKubernetes returns a pointer to Kubernetes without any client.
+func NewKubernetes() *Kubernetes {
+	return &Kubernetes{
+		Now:     time.Now,
+		clients: make(map[kubeTarget]*kubeClient),
+	}
+}
+
+// Client of the Kubernetes API.
+type kubeClient struct {
+	server string
+	http   *http.Client
+	// Returns the bearer token of the requests, empty for none
+	token func(ctx context.Context) (string, error)
+	// Drops the cached token once rejected by the API server, nil when tokens are not cached
+	forget func()
+}
+
+// Returns the client of a kubeconfig file and context, the current context by default.
+// An empty kubeconfig stands for the in-cluster configuration when running in a pod,
+// the one of KUBECONFIG or ~/.kube/config otherwise.
+func (k *Kubernetes) client(kubeconfig, kubeContext string) (*kubeClient, error) {
+	target := kubeTarget{kubeconfig: kubeconfig, context: kubeContext}
+
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if client, exists := k.clients[target]; exists {
+		return client, nil
+	}
+
+	var client *kubeClient
+	var err error
+	if kubeconfig == "" && os.Getenv("KUBECONFIG") == "" && os.Getenv("KUBERNETES_SERVICE_HOST") != "" {
+		client, err = inClusterClient()
+	} else {
+		client, err = k.kubeconfigClient(kubeconfig, kubeContext)
+	}
+	if err != nil {
+		return nil, err
+	}
+	k.clients[target] = client
+	return client, nil
+}
+
+// Client of the service account of the pod. Its token is read on each request, as it is rotated.
+func inClusterClient() (*kubeClient, error) {
+	ca, err := os.ReadFile(serviceAccountCA)
+	if err != nil {
+		return nil, err
+	}
+	httpClient, err := tlsClient(ca, nil, false)
+	if err != nil {
+		return nil, err
+	}
+
+	return &kubeClient{
+		server: "https://" + net.JoinHostPort(os.Getenv("KUBERNETES_SERVICE_HOST"), os.Getenv("KUBERNETES_SERVICE_PORT")),
+		http:   httpClient,
+		token: func(ctx context.Context) (string, error) {
+			token, err := os.ReadFile(serviceAccountToken)
+			return strings.TrimSpace(string(token)), err
+		},
+	}, nil
+}
+
+// Structure of a kubeconfig file, limited to what is supported.
+type kubeconfigFile struct {
+	CurrentContext string `yaml:"current-context"`
+	Contexts       []struct {
+		Name    string
+		Context struct {
+			Cluster string
+			User    string
+		}
+	}
+	Clusters []struct {
+		Name    string
+		Cluster struct {
+			Server                   string
+			CertificateAuthority     string `yaml:"certificate-authority"`
+			CertificateAuthorityData string `yaml:"certificate-authority-data"`
+			InsecureSkipTLSVerify    bool   `yaml:"insecure-skip-tls-verify"`
+		}
+	}
+	Users []struct {
+		Name string
+		User struct {
+			Token                 string
+			TokenFile             string `yaml:"tokenFile"`
+			ClientCertificate     string `yaml:"client-certificate"`
+			ClientCertificateData string `yaml:"client-certificate-data"`
+			ClientKey             string `yaml:"client-key"`
+			ClientKeyData         string `yaml:"client-key-data"`
+			Exec                  *kubeExec
+		}
+	}
+}
+
+// Credential plugin of a kubeconfig user, e.g. aws eks get-token.
+type kubeExec struct {
+	APIVersion string `yaml:"apiVersion"`
+	Command    string
+	Args       []string
+	Env        []struct {
+		Name  string
+		Value string
+	}
+}
+
+// Client of a context of a kubeconfig file. Token and client certificates are supported as
+// user credentials, and credential plugins whose tokens are cached until they expire.
+func (k *Kubernetes) kubeconfigClient(path, kubeContext string) (*kubeClient, error) {
+	if path == "" {
+		path, _, _ = strings.Cut(os.Getenv("KUBECONFIG"), string(filepath.ListSeparator))
+	}
+	if path == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return nil, err
+		}
+		path = filepath.Join(home, ".kube", "config")
+	}
+
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var config kubeconfigFile
+	if err := yaml.Unmarshal(content, &config); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	dir := filepath.Dir(path)
+
+	if kubeContext == "" {
+		kubeContext = config.CurrentContext
+	}
+	var clusterName, userName string
+	found := false
+	for _, c := range config.Contexts {
+		if c.Name == kubeContext {
+			clusterName, userName, found = c.Context.Cluster, c.Context.User, true
+		}
+	}
+	if !found {
+		return nil, fmt.Errorf("%s: no context %q", path, kubeContext)
+	}
+
+	client := &kubeClient{token: func(ctx context.Context) (string, error) { return "", nil }}
+	var ca []byte
+	insecure := false
+	found = false
+	for _, c := range config.Clusters {
+		if c.Name != clusterName {
+			continue
+		}
+		found = true
+		client.server = strings.TrimSuffix(c.Cluster.Server, "/")
+		insecure = c.Cluster.InsecureSkipTLSVerify
+		if ca, err = fileOrData(dir, c.Cluster.CertificateAuthority, c.Cluster.CertificateAuthorityData); err != nil {
+			return nil, err
+		}
+	}
+	if !found {
+		return nil, fmt.Errorf("%s: no cluster %q", path, clusterName)
+	}
+
+	var certificates []tls.Certificate
+	for _, u := range config.Users {
+		if u.Name != userName {
+			continue
+		}
+		user := u.User
+		switch {
+		case user.Token != "":
+			client.token = func(ctx context.Context) (string, error) { return user.Token, nil }
+		case user.TokenFile != "":
+			tokenFile := resolve(dir, user.TokenFile)
+			client.token = func(ctx context.Context) (string, error) {
+				token, err := os.ReadFile(tokenFile)
+				return strings.TrimSpace(string(token)), err
+			}
+		case user.Exec != nil:
+			client.token, client.forget = k.execToken(user.Exec, dir)
+		}
+
+		cert, err := fileOrData(dir, user.ClientCertificate, user.ClientCertificateData)
+		if err != nil {
+			return nil, err
+		}
+		key, err := fileOrData(dir, user.ClientKey, user.ClientKeyData)
+		if err != nil {
+			return nil, err
+		}
+		if cert != nil {
+			certificate, err := tls.X509KeyPair(cert, key)
+			if err != nil {
+				return nil, fmt.Errorf("%s: user %s: %w", path, userName, err)
+			}
+			certificates = append(certificates, certificate)
+		}
+	}
+
+	if client.http, err = tlsClient(ca, certificates, insecure); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return client, nil
+}
+
+// Returns a function running a credential plugin for its token, cached until it expires,
+// and a function dropping the cached token.
+func (k *Kubernetes) execToken(plugin *kubeExec, dir string) (func(ctx context.Context) (string, error), func()) {
+	var (
+		mu      sync.Mutex
+		token   string
+		expires time.Time
+	)
+
+	get := func(ctx context.Context) (string, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		if token != "" && (expires.IsZero() || k.Now().Before(expires)) {
+			return token, nil
+		}
+
+		command := plugin.Command
+		if strings.Contains(command, string(filepath.Separator)) {
+			command = resolve(dir, command)
+		}
+		cmd := process.Command(ctx, command, plugin.Args...)
+		cmd.Env = os.Environ()
+		for _, env := range plugin.Env {
+			cmd.Env = append(cmd.Env, env.Name+"="+env.Value)
+		}
+		cmd.Env = append(cmd.Env, `KUBERNETES_EXEC_INFO={"apiVersion":"`+plugin.APIVersion+`","kind":"ExecCredential","spec":{"interactive":false}}`)
+		var stdout, stderr bytes.Buffer
+		cmd.Stdout = &stdout
+		cmd.Stderr = &stderr
+
+		err := cmd.Start()
+		if err == nil {
+			err = process.Wait(ctx, cmd)
+		}
+		if err != nil {
+			return "", fmt.Errorf("credential plugin %s: %w: %s", plugin.Command, err, strings.TrimSpace(stderr.String()))
+		}
+		var credential struct {
+			Status struct {
+				Token               string
+				ExpirationTimestamp time.Time
+			}
+		}
+		if err := json.Unmarshal(stdout.Bytes(), &credential); err != nil {
+			return "", fmt.Errorf("credential plugin %s: %w", plugin.Command, err)
+		}
+		if credential.Status.Token == "" {
+			return "", fmt.Errorf("credential plugin %s: no token", plugin.Command)
+		}
+		token, expires = credential.Status.Token, credential.Status.ExpirationTimestamp
+		return token, nil
+	}
+	forget := func() {
+		mu.Lock()
+		defer mu.Unlock()
+		token = ""
+	}
+	return get, forget
+}
+
+// HTTP client trusting the PEM certificates of ca, the system ones when empty.
+func tlsClient(ca []byte, certificates []tls.Certificate, insecure bool) (*http.Client, error) {
+	config := &tls.Config{Certificates: certificates, InsecureSkipVerify: insecure}
+	if len(ca) > 0 {
+		config.RootCAs = x509.NewCertPool()
+		if !config.RootCAs.AppendCertsFromPEM(ca) {
+			return nil, errors.New("invalid certificate authority")
+		}
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = config
+	return &http.Client{Transport: transport, Timeout: kubernetesTimeout}, nil
+}
+
+// Content of a file, relative to dir, or base64 encoded data. Nil when both are empty.
+func fileOrData(dir, file, data string) ([]byte, error) {
+	if data != "" {
+		return base64.StdEncoding.DecodeString(data)
+	}
+	if file != "" {
+		return os.ReadFile(resolve(dir, file))
+	}
+	return nil, nil
+}
+
+func resolve(dir, path string) string {
+	if filepath.IsAbs(path) {
+		return path
+	}
+	return filepath.Join(dir, path)
+}
+
+// Send a GET request of path to the API server, returning the response when its status is OK.
+func (c *kubeClient) get(ctx context.Context, path string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.server+path, nil)
+	if err != nil {
+		return nil, err
+	}
+	token, err := c.token(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode == http.StatusUnauthorized && c.forget != nil {
+		c.forget()
+	}
+	if resp.StatusCode != http.StatusOK {
+		defer resp.Body.Close()
+		var status struct{ Message string }
+		json.NewDecoder(resp.Body).Decode(&status)
+		return nil, fmt.Errorf("%s: %s %s", path, resp.Status, status.Message)
+	}
+	return resp, nil
+}
@@ -0,0 +1,255 @@
+package lookable
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/AirVantage/overlord/pkg/backoff"
+)
+
+// Maximum duration of a Kubernetes watch, before it is started again.
+const kubernetesWatchTimeout = 5 * time.Minute
+
+// Timeout of the requests to the API server, longer than its watches.
+const kubernetesTimeout = kubernetesWatchTimeout + 30*time.Second
+
+// KubernetesService is a Lookable service of a Kubernetes cluster, whose members are the ready
+// endpoints of its EndpointSlices.
+type KubernetesService struct {
+	Namespace string
+	Service   string
+	// Name of the port of the members, all the ports of the endpoints by default
+	Port string
+	// Path of the kubeconfig file, the in-cluster configuration when running in a pod,
+	// or KUBECONFIG, or ~/.kube/config by default
+	Kubeconfig string
+	// Context of the kubeconfig file, its current context by default
+	Context string
+}
+
+func (s KubernetesService) String() string {
+	return s.Namespace + "/" + s.Service
+}
+
+// Validate the Kubernetes service configuration.
+func (s KubernetesService) Validate() error {
+	if s.Namespace == "" || s.Service == "" {
+		return errors.New("kubernetes requires a namespace and a service")
+	}
+	return nil
+}
+
+// EndpointSlice of the discovery.k8s.io/v1 API.
+type endpointSlice struct {
+	Metadata struct {
+		Name            string
+		ResourceVersion string
+	}
+	AddressType string
+	Endpoints   []struct {
+		Addresses  []string
+		Conditions struct {
+			// Unknown readiness is interpreted as ready
+			Ready *bool
+		}
+		Hostname  string
+		NodeName  string
+		Zone      string
+		TargetRef *struct {
+			Kind string
+			Name string
+		}
+	}
+	Ports []struct {
+		Name     string
+		Port     *int
+		Protocol string
+	}
+}
+
+// LookupMembers of the ready endpoints of the service, in IPv4 or IPv6 EndpointSlices.
+// Each endpoint address is a member for each port of its slice, with the name and protocol
+// of the port, and the hostname, node, zone and pod of the endpoint in its metadata.
+func (s KubernetesService) LookupMembers(ctx context.Context, clients *Clients, ipv6 bool) ([]Member, error) {
+	slices, _, err := s.list(ctx, clients)
+	if err != nil {
+		return nil, err
+	}
+
+	addressType := "IPv4"
+	if ipv6 {
+		addressType = "IPv6"
+	}
+
+	members := []Member{}
+	for _, slice := range slices {
+		if slice.AddressType != addressType {
+			continue
+		}
+		for _, endpoint := range slice.Endpoints {
+			if ready := endpoint.Conditions.Ready; ready != nil && !*ready {
+				continue
+			}
+			meta := map[string]string{"slice": slice.Metadata.Name}
+			for name, value := range map[string]string{"hostname": endpoint.Hostname, "node": endpoint.NodeName, "zone": endpoint.Zone} {
+				if value != "" {
+					meta[name] = value
+				}
+			}
+			if endpoint.TargetRef != nil && endpoint.TargetRef.Kind == "Pod" {
+				meta["pod"] = endpoint.TargetRef.Name
+			}
+
+			for _, ip := range endpoint.Addresses {
+				if len(slice.Ports) == 0 && s.Port == "" {
+					members = append(members, Member{IP: ip, Meta: meta})
+				}
+				for _, port := range slice.Ports {
+					if port.Port == nil || (s.Port != "" && port.Name != s.Port) {
+						continue
+					}
+					member := Member{IP: ip, Port: *port.Port, Meta: make(map[string]string, len(meta)+2)}
+					for name, value := range meta {
+						member.Meta[name] = value
+					}
+					if port.Name != "" {
+						member.Meta["port_name"] = port.Name
+					}
+					if port.Protocol != "" {
+						member.Meta["protocol"] = port.Protocol
+					}
+					members = append(members, member)
+				}
+			}
+		}
+	}
+	return members, nil
+}
+
+// Implement public interface
+func (s KubernetesService) LookupIPs(ctx context.Context, clients *Clients, ipv6 bool) ([]string, error) {
+	members, err := s.LookupMembers(ctx, clients, ipv6)
+	if err != nil {
+		return nil, err
+	}
+	return IPs(members), nil
+}
+
+// Watch the EndpointSlices of the service, calling changed whenever one is added, modified or deleted.
+func (s KubernetesService) Watch(ctx context.Context, clients *Clients, changed func()) error {
+	_, resourceVersion, err := s.list(ctx, clients)
+	if err != nil {
+		return err
+	}
+	client, err := s.client(clients)
+	if err != nil {
+		return err
+	}
+
+	reconnect := &backoff.Backoff{Min: watchBackoffMin, Max: watchBackoffMax}
+	for {
+		params := s.selector()
+		params.Set("watch", "true")
+		params.Set("allowWatchBookmarks", "true")
+		params.Set("resourceVersion", resourceVersion)
+		params.Set("timeoutSeconds", strconv.Itoa(int(kubernetesWatchTimeout.Seconds())))
+		resp, err := client.get(ctx, s.path()+"?"+params.Encode())
+		if err != nil {
+			return fmt.Errorf("kubernetes service %s: watch: %w", s, err)
+		}
+
+		// The watch ends on timeout, it is started again from the last version, later when it got no event
+		decoder := json.NewDecoder(resp.Body)
+		events := false
+		for {
+			var event struct {
+				Type   string
+				Object json.RawMessage
+			}
+			if err := decoder.Decode(&event); err != nil {
+				resp.Body.Close()
+				if ctx.Err() != nil {
+					return ctx.Err()
+				}
+				break
+			}
+			events = true
+
+			// Errors like an expired version end the watch, to be listed again
+			if event.Type == "ERROR" {
+				resp.Body.Close()
+				var status struct{ Message string }
+				json.Unmarshal(event.Object, &status)
+				return fmt.Errorf("kubernetes service %s: watch: %s", s, status.Message)
+			}
+			var slice endpointSlice
+			if err := json.Unmarshal(event.Object, &slice); err != nil {
+				resp.Body.Close()
+				return fmt.Errorf("kubernetes service %s: %w", s, err)
+			}
+			resourceVersion = slice.Metadata.ResourceVersion
+			if event.Type != "BOOKMARK" {
+				changed()
+			}
+		}
+
+		if events {
+			reconnect.Reset()
+		} else if err := sleep(ctx, reconnect.Next()); err != nil {
+			return err
+		}
+	}
+}
+
+// List the EndpointSlices of the service, and their resource version.
+func (s KubernetesService) list(ctx context.Context, clients *Clients) ([]endpointSlice, string, error) {
+	client, err := s.client(clients)
+	if err != nil {
+		return nil, "", err
+	}
+	resp, err := client.get(ctx, s.path()+"?"+s.selector().Encode())
+	if err != nil {
+		return nil, "", fmt.Errorf("kubernetes service %s: %w", s, err)
+	}
+	defer resp.Body.Close()
+
+	var list struct {
+		Metadata struct {
+			ResourceVersion string
+		}
+		Items []endpointSlice
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&list); err != nil {
+		return nil, "", fmt.Errorf("kubernetes service %s: %w", s, err)
+	}
+	return list.Items, list.Metadata.ResourceVersion, nil
+}
+
+func (s KubernetesService) client(clients *Clients) (*kubeClient, error) {
+	var kubernetes *Kubernetes
+	if clients != nil {
+		kubernetes = clients.Kubernetes
+	}
+	if kubernetes == nil {
+		kubernetes = NewKubernetes()
+	}
+	client, err := kubernetes.client(s.Kubeconfig, s.Context)
+	if err != nil {
+		return nil, fmt.Errorf("kubernetes service %s: %w", s, err)
+	}
+	return client, nil
+}
+
+func (s KubernetesService) path() string {
+	return "/apis/discovery.k8s.io/v1/namespaces/" + url.PathEscape(s.Namespace) + "/endpointslices"
+}
+
+// Selects the EndpointSlices of the service.
+func (s KubernetesService) selector() url.Values {
+	return url.Values{"labelSelector": {"kubernetes.io/service-name=" + s.Service}}
+}
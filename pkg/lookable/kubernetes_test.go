@@ -0,0 +1,315 @@
+package lookable
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+// Fake Kubernetes API server serving the EndpointSlices of a namespace over TLS, watches
+// streaming the events sent to it until an empty one.
+type kubeServer struct {
+	server *httptest.Server
+	slices string
+	events chan string
+	// Authorization headers of the requests
+	authorizations []string
+	queries        []string
+	// Whether the requests are rejected as unauthorized
+	unauthorized bool
+	// Protects all the fields above but events
+	mu sync.Mutex
+}
+
+func newKubeServer(t *testing.T) *kubeServer {
+	s := &kubeServer{slices: "[]", events: make(chan string)}
+	s.server = httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		s.mu.Lock()
+		s.authorizations = append(s.authorizations, req.Header.Get("Authorization"))
+		s.queries = append(s.queries, req.URL.RequestURI())
+		slices, unauthorized := s.slices, s.unauthorized
+		s.mu.Unlock()
+
+		if unauthorized {
+			w.WriteHeader(http.StatusUnauthorized)
+			w.Write([]byte(`{"message": "Unauthorized"}`))
+			return
+		}
+
+		if req.URL.Path != "/apis/discovery.k8s.io/v1/namespaces/prod/endpointslices" {
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"message": "not found"}`))
+			return
+		}
+		if req.URL.Query().Get("watch") != "true" {
+			w.Write([]byte(`{"metadata": {"resourceVersion": "10"}, "items": ` + slices + `}`))
+			return
+		}
+
+		w.(http.Flusher).Flush()
+		for {
+			select {
+			case event := <-s.events:
+				if event == "" {
+					return
+				}
+				w.Write([]byte(event + "\n"))
+				w.(http.Flusher).Flush()
+			case <-req.Context().Done():
+				return
+			}
+		}
+	}))
+	t.Cleanup(s.server.Close)
+	return s
+}
+
+// Write a kubeconfig file of the server, whose user has the given YAML definition.
+func (s *kubeServer) kubeconfig(t *testing.T, user string) string {
+	ca := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: s.server.Certificate().Raw})
+	path := filepath.Join(t.TempDir(), "config")
+	content := `apiVersion: v1
+kind: Config
+current-context: test
+contexts:
+- name: test
+  context: {cluster: test, user: test}
+- name: other
+  context: {cluster: other, user: test}
+clusters:
+- name: test
+  cluster:
+    server: ` + s.server.URL + `
+    certificate-authority-data: ` + base64.StdEncoding.EncodeToString(ca) + `
+users:
+- name: test
+  user:
+` + user
+	if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func (s *kubeServer) requests() ([]string, []string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	authorizations, queries := s.authorizations, s.queries
+	s.authorizations, s.queries = nil, nil
+	return authorizations, queries
+}
+
+func TestKubernetesLookupMembers(t *testing.T) {
+	s := newKubeServer(t)
+	s.slices = `[
+		{"metadata": {"name": "web-v4"}, "addressType": "IPv4",
+		 "endpoints": [
+			{"addresses": ["10.0.0.2"], "conditions": {"ready": true}, "nodeName": "node-2", "zone": "eu-west-1b", "targetRef": {"kind": "Pod", "name": "web-2"}},
+			{"addresses": ["10.0.0.1"], "conditions": {}, "nodeName": "node-1"},
+			{"addresses": ["10.0.0.3"], "conditions": {"ready": false}}
+		 ],
+		 "ports": [{"name": "http", "port": 8080, "protocol": "TCP"}, {"name": "metrics", "port": 9090, "protocol": "TCP"}]},
+		{"metadata": {"name": "web-v6"}, "addressType": "IPv6",
+		 "endpoints": [{"addresses": ["2001:db8::1"]}],
+		 "ports": [{"port": 8080}]}
+	]`
+	kubeconfig := s.kubeconfig(t, "    token: secret\n")
+
+	cases := []struct {
+		service KubernetesService
+		ipv6    bool
+		expect  []Member
+		err     bool
+	}{
+		/* Ready endpoints, for each port */
+		{
+			service: KubernetesService{Namespace: "prod", Service: "web"},
+			expect: []Member{
+				{IP: "10.0.0.2", Port: 8080, Meta: map[string]string{"slice": "web-v4", "node": "node-2", "zone": "eu-west-1b", "pod": "web-2", "port_name": "http", "protocol": "TCP"}},
+				{IP: "10.0.0.2", Port: 9090, Meta: map[string]string{"slice": "web-v4", "node": "node-2", "zone": "eu-west-1b", "pod": "web-2", "port_name": "metrics", "protocol": "TCP"}},
+				{IP: "10.0.0.1", Port: 8080, Meta: map[string]string{"slice": "web-v4", "node": "node-1", "port_name": "http", "protocol": "TCP"}},
+				{IP: "10.0.0.1", Port: 9090, Meta: map[string]string{"slice": "web-v4", "node": "node-1", "port_name": "metrics", "protocol": "TCP"}},
+			},
+		},
+		/* Named port */
+		{
+			service: KubernetesService{Namespace: "prod", Service: "web", Port: "http"},
+			expect: []Member{
+				{IP: "10.0.0.2", Port: 8080, Meta: map[string]string{"slice": "web-v4", "node": "node-2", "zone": "eu-west-1b", "pod": "web-2", "port_name": "http", "protocol": "TCP"}},
+				{IP: "10.0.0.1", Port: 8080, Meta: map[string]string{"slice": "web-v4", "node": "node-1", "port_name": "http", "protocol": "TCP"}},
+			},
+		},
+		/* IPv6 slices */
+		{
+			service: KubernetesService{Namespace: "prod", Service: "web"},
+			ipv6:    true,
+			expect:  []Member{{IP: "2001:db8::1", Port: 8080, Meta: map[string]string{"slice": "web-v6"}}},
+		},
+		/* Unknown namespace */
+		{
+			service: KubernetesService{Namespace: "dev", Service: "web"},
+			err:     true,
+		},
+		/* Unknown cluster of a context */
+		{
+			service: KubernetesService{Namespace: "prod", Service: "web", Context: "other"},
+			err:     true,
+		},
+	}
+
+	for i, tt := range cases {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			tt.service.Kubeconfig = kubeconfig
+			members, err := tt.service.LookupMembers(context.Background(), &Clients{}, tt.ipv6)
+			if tt.err {
+				if err == nil {
+					t.Errorf("expect an error, got %v", members)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(members, tt.expect) {
+				t.Errorf("expect %v, got %v", tt.expect, members)
+			}
+
+			authorizations, queries := s.requests()
+			if expect := []string{"Bearer secret"}; !Equal(authorizations, expect) {
+				t.Errorf("expect %v, got %v", expect, authorizations)
+			}
+			if expect := []string{"/apis/discovery.k8s.io/v1/namespaces/prod/endpointslices?labelSelector=kubernetes.io%2Fservice-name%3Dweb"}; !Equal(queries, expect) {
+				t.Errorf("expect %v, got %v", expect, queries)
+			}
+		})
+	}
+}
+
+func TestKubernetesExecToken(t *testing.T) {
+	s := newKubeServer(t)
+	dir := t.TempDir()
+	plugin := filepath.Join(dir, "get-token")
+	// Counts its runs in a file, to check that its token is cached
+	script := `#!/bin/sh
+echo run >> "` + filepath.Join(dir, "runs") + `"
+echo '{"apiVersion": "client.authentication.k8s.io/v1beta1", "kind": "ExecCredential", "status": {"token": "'$TOKEN'", "expirationTimestamp": "2024-01-01T00:15:00Z"}}'
+`
+	if err := os.WriteFile(plugin, []byte(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+	kubeconfig := s.kubeconfig(t, `    exec:
+      apiVersion: client.authentication.k8s.io/v1beta1
+      command: `+plugin+`
+      env: [{name: TOKEN, value: from-plugin}]
+`)
+
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	kubernetes := NewKubernetes()
+	kubernetes.Now = func() time.Time { return now }
+	clients := &Clients{Kubernetes: kubernetes}
+
+	cases := []struct {
+		elapsed      time.Duration
+		unauthorized bool
+		runs         int
+	}{
+		/* First lookup */
+		{runs: 1},
+		/* Cached token */
+		{elapsed: 14 * time.Minute, runs: 1},
+		/* Token rejected by the API server */
+		{unauthorized: true, runs: 1},
+		/* Rejected token not reused */
+		{runs: 2},
+		/* Expired token */
+		{elapsed: time.Minute, runs: 3},
+	}
+
+	for i, tt := range cases {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			now = now.Add(tt.elapsed)
+			s.mu.Lock()
+			s.unauthorized = tt.unauthorized
+			s.mu.Unlock()
+			_, err := (KubernetesService{Namespace: "prod", Service: "web", Kubeconfig: kubeconfig}).LookupMembers(context.Background(), clients, false)
+			if (err != nil) != tt.unauthorized {
+				t.Fatalf("expect error %v, got %v", tt.unauthorized, err)
+			}
+			if authorizations, _ := s.requests(); !Equal(authorizations, []string{"Bearer from-plugin"}) {
+				t.Errorf("expect the token of the plugin, got %v", authorizations)
+			}
+			content, _ := os.ReadFile(filepath.Join(dir, "runs"))
+			if runs := len(content) / len("run\n"); runs != tt.runs {
+				t.Errorf("expect %v runs, got %v", tt.runs, runs)
+			}
+		})
+	}
+}
+
+func TestKubernetesWatch(t *testing.T) {
+	s := newKubeServer(t)
+	service := KubernetesService{Namespace: "prod", Service: "web", Kubeconfig: s.kubeconfig(t, "    token: secret\n")}
+
+	changed := make(chan struct{}, 10)
+	done := make(chan error)
+	go func() {
+		done <- service.Watch(context.Background(), &Clients{}, func() { changed <- struct{}{} })
+	}()
+
+	cases := []struct {
+		event   string
+		changed bool
+	}{
+		/* Added slice */
+		{event: `{"type": "ADDED", "object": {"metadata": {"name": "web-1", "resourceVersion": "11"}}}`, changed: true},
+		/* Bookmark */
+		{event: `{"type": "BOOKMARK", "object": {"metadata": {"resourceVersion": "12"}}}`},
+		/* Modified slice */
+		{event: `{"type": "MODIFIED", "object": {"metadata": {"name": "web-1", "resourceVersion": "13"}}}`, changed: true},
+	}
+
+	for i, tt := range cases {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			s.events <- tt.event
+			// The next event is read once the previous one was handled
+			select {
+			case <-changed:
+				if !tt.changed {
+					t.Error("expect no change")
+				}
+			case <-time.After(100 * time.Millisecond):
+				if tt.changed {
+					t.Error("expect a change")
+				}
+			}
+		})
+	}
+
+	// The watch starts again from the last version when it ends, after a delay when it got no event
+	s.requests()
+	s.events <- ""
+	begin := time.Now()
+	s.events <- ""
+	s.events <- `{"type": "ERROR", "object": {"kind": "Status", "code": 410, "message": "too old resource version"}}`
+	// Errors end the watch
+	if err := <-done; err == nil {
+		t.Error("expect an error")
+	}
+	if elapsed := time.Since(begin); elapsed < watchBackoffMin/2 {
+		t.Errorf("expect the watch started again after %v, got %v", watchBackoffMin/2, elapsed)
+	}
+	_, queries := s.requests()
+	watch := "/apis/discovery.k8s.io/v1/namespaces/prod/endpointslices?allowWatchBookmarks=true&labelSelector=kubernetes.io%2Fservice-name%3Dweb&resourceVersion=13&timeoutSeconds=300&watch=true"
+	if expect := []string{watch, watch}; !Equal(queries, expect) {
+		t.Errorf("expect %v, got %v", expect, queries)
+	}
+}
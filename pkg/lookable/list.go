@@ -0,0 +1,49 @@
+package lookable
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// List is a set of strings kept comparable, for lookables to be used as map keys.
+// It is given as an array in the resource configuration.
+type List string
+
+// NewList returns the List of values.
+func NewList(values ...string) List {
+	values = slices.Clone(values)
+	slices.Sort(values)
+	return List(strings.Join(slices.Compact(values), ","))
+}
+
+// Values of the list, sorted.
+func (l List) Values() []string {
+	if l == "" {
+		return nil
+	}
+	return strings.Split(string(l), ",")
+}
+
+// Has tells whether value belongs to the list.
+func (l List) Has(value string) bool {
+	return slices.Contains(l.Values(), value)
+}
+
+// UnmarshalTOML reads the list from an array of strings.
+func (l *List) UnmarshalTOML(data any) error {
+	items, ok := data.([]any)
+	if !ok {
+		return fmt.Errorf("expect an array of strings, got %v", data)
+	}
+	values := make([]string, 0, len(items))
+	for _, item := range items {
+		value, ok := item.(string)
+		if !ok || value == "" || strings.Contains(value, ",") {
+			return fmt.Errorf("invalid list value %v", item)
+		}
+		values = append(values, value)
+	}
+	*l = NewList(values...)
+	return nil
+}
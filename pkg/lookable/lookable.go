@@ -2,13 +2,88 @@ package lookable
 
 import (
 	"context"
-
-	"github.com/aws/aws-sdk-go-v2/aws"
+	"fmt"
+	"reflect"
+	"strings"
 )
 
 // Lookable is a group of cloud instances.
 type Lookable interface {
 	// LookupIPs returns the list of IP addresses of the Lookable instances, in IPv4 or IPv6.
-	LookupIPs(ctx context.Context, cfg aws.Config, ipv6 bool) ([]string, error)
+	LookupIPs(ctx context.Context, clients *Clients, ipv6 bool) ([]string, error)
 	String() string
 }
+
+// Parse returns the Lookable of a kind, named after the resource keys: group, tag, subnet, file,
+// dns for A or AAAA records or srv for SRV records, resolved with the default resolver,
+// target_group for its healthy targets, ecs_service for the tasks of a cluster/service,
+// cloud_map for the healthy instances of a namespace/service, consul for the passing
+// instances of a service[@datacenter], kubernetes for the ready endpoints of a namespace/service,
+// docker for the running containers having comma separated labels, exec for the members printed
+// by a command, or http for the members given by a URL.
+func Parse(kind, name string) (Lookable, error) {
+	switch kind {
+	case "group":
+		return AutoScalingGroup(name), nil
+	case "tag":
+		return Tag(name), nil
+	case "subnet":
+		return Subnet(name), nil
+	case "file":
+		return File(name), nil
+	case "dns":
+		return DNS{Name: name}, nil
+	case "srv":
+		return DNS{Name: name, Type: DNSTypeSRV}, nil
+	case "target_group":
+		return TargetGroup{Name: name}, nil
+	case "ecs_service":
+		cluster, service, found := strings.Cut(name, "/")
+		if !found {
+			return nil, fmt.Errorf("expect cluster/service, got %s", name)
+		}
+		return ECSService{Cluster: cluster, Service: service}, nil
+	case "cloud_map":
+		namespace, service, found := strings.Cut(name, "/")
+		if !found {
+			return nil, fmt.Errorf("expect namespace/service, got %s", name)
+		}
+		return CloudMapService{Namespace: namespace, Service: service}, nil
+	case "consul":
+		service, datacenter, _ := strings.Cut(name, "@")
+		return ConsulService{Service: service, Datacenter: datacenter}, nil
+	case "kubernetes":
+		namespace, service, found := strings.Cut(name, "/")
+		if !found {
+			return nil, fmt.Errorf("expect namespace/service, got %s", name)
+		}
+		return KubernetesService{Namespace: namespace, Service: service}, nil
+	case "docker":
+		return DockerContainers{Labels: NewList(strings.Split(name, ",")...)}, nil
+	case "exec":
+		return Exec{Command: name}, nil
+	case "http":
+		return HTTP{URL: name}, nil
+	default:
+		return nil, fmt.Errorf("unknown lookable kind %s", kind)
+	}
+}
+
+// CheckNames returns an error when distinct lookables share the same name, as their IPs and
+// members are known by their name: a DNS name resolved with different types, a target group
+// keeping different health states, or lookables of different kinds.
+func CheckNames(lookables []Lookable) error {
+	names := make(map[string]Lookable)
+	for _, l := range lookables {
+		other, exists := names[l.String()]
+		if !exists || other == l {
+			names[l.String()] = l
+			continue
+		}
+		if reflect.TypeOf(other) == reflect.TypeOf(l) {
+			return fmt.Errorf("%T %s watched with different settings", l, l)
+		}
+		return fmt.Errorf("lookables %T and %T share the name %s", other, l, l)
+	}
+	return nil
+}
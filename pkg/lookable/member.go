@@ -0,0 +1,183 @@
+package lookable
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"net"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Member is an instance of a Lookable, with the details known about it beside its IP.
+type Member struct {
+	IP   string            `json:"ip" yaml:"ip"`
+	Port int               `json:"port,omitempty" yaml:"port,omitempty"`
+	Meta map[string]string `json:"meta,omitempty" yaml:"meta,omitempty"`
+}
+
+// MemberLookable is a Lookable knowing the port and metadata of its members.
+type MemberLookable interface {
+	Lookable
+	// LookupMembers returns the members of the Lookable, in IPv4 or IPv6.
+	LookupMembers(ctx context.Context, clients *Clients, ipv6 bool) ([]Member, error)
+}
+
+// Lookup returns the members of l sorted by IP and port. Members of a Lookable other than
+// a MemberLookable only have an IP.
+func Lookup(ctx context.Context, l Lookable, clients *Clients, ipv6 bool) ([]Member, error) {
+	if ml, ok := l.(MemberLookable); ok {
+		members, err := ml.LookupMembers(ctx, clients, ipv6)
+		if err != nil {
+			return nil, err
+		}
+		SortMembers(members)
+		return members, nil
+	}
+
+	ips, err := l.LookupIPs(ctx, clients, ipv6)
+	if err != nil {
+		return nil, err
+	}
+	return Members(ips), nil
+}
+
+// Members returns the members having the given IPs, sorted.
+func Members(ips []string) []Member {
+	members := make([]Member, 0, len(ips))
+	for _, ip := range ips {
+		members = append(members, Member{IP: ip})
+	}
+	SortMembers(members)
+	return members
+}
+
+// IPs returns the sorted IPs of members, without duplicates.
+func IPs(members []Member) []string {
+	ips := make([]string, 0, len(members))
+	for _, member := range members {
+		ips = append(ips, member.IP)
+	}
+	slices.Sort(ips)
+	return slices.Compact(ips)
+}
+
+// SortMembers sorts members by IP and port.
+func SortMembers(members []Member) {
+	slices.SortStableFunc(members, func(a, b Member) int {
+		if c := strings.Compare(a.IP, b.IP); c != 0 {
+			return c
+		}
+		return a.Port - b.Port
+	})
+}
+
+// EqualMembers tells whether a and b hold the same members, in the same order.
+func EqualMembers(a, b []Member) bool {
+	return slices.EqualFunc(a, b, func(m, n Member) bool {
+		return m.IP == n.IP && m.Port == n.Port && maps.Equal(m.Meta, n.Meta)
+	})
+}
+
+func (m *Member) validate() error {
+	if net.ParseIP(m.IP) == nil {
+		return fmt.Errorf("invalid IP %q", m.IP)
+	}
+	if m.Port < 0 || m.Port > 65535 {
+		return fmt.Errorf("invalid port %d of %s", m.Port, m.IP)
+	}
+	return nil
+}
+
+// member has the fields of Member without its unmarshal methods.
+type member Member
+
+// UnmarshalJSON reads a member given either as an IP string or as an object.
+func (m *Member) UnmarshalJSON(data []byte) error {
+	if bytes.HasPrefix(bytes.TrimSpace(data), []byte(`"`)) {
+		*m = Member{}
+		if err := json.Unmarshal(data, &m.IP); err != nil {
+			return err
+		}
+	} else if err := json.Unmarshal(data, (*member)(m)); err != nil {
+		return err
+	}
+	return m.validate()
+}
+
+// UnmarshalYAML reads a member given either as an IP string or as a mapping.
+func (m *Member) UnmarshalYAML(value *yaml.Node) error {
+	if value.Kind == yaml.ScalarNode {
+		*m = Member{IP: value.Value}
+	} else if err := value.Decode((*member)(m)); err != nil {
+		return err
+	}
+	return m.validate()
+}
+
+// ParseMembers reads the members listed in the content of the file name: a JSON or YAML list
+// according to its extension, or otherwise one IP per line, ignoring blank lines and # comments.
+func ParseMembers(name string, content []byte) ([]Member, error) {
+	var members []Member
+
+	switch filepath.Ext(name) {
+	case ".json":
+		if err := json.Unmarshal(content, &members); err != nil {
+			return nil, fmt.Errorf("%s: %w", name, err)
+		}
+	case ".yaml", ".yml":
+		if err := yaml.Unmarshal(content, &members); err != nil {
+			return nil, fmt.Errorf("%s: %w", name, err)
+		}
+	default:
+		scanner := bufio.NewScanner(bytes.NewReader(content))
+		for line := 1; scanner.Scan(); line++ {
+			ip, _, _ := strings.Cut(scanner.Text(), "#")
+			ip = strings.TrimSpace(ip)
+			if ip == "" {
+				continue
+			}
+			m := Member{IP: ip}
+			if err := m.validate(); err != nil {
+				return nil, fmt.Errorf("%s:%d: %w", name, line, err)
+			}
+			members = append(members, m)
+		}
+		if err := scanner.Err(); err != nil {
+			return nil, fmt.Errorf("%s: %w", name, err)
+		}
+	}
+	return members, nil
+}
+
+// Delays between the reconnections of a watch which ended without any change, like when its
+// server keeps closing the connection.
+const (
+	watchBackoffMin = time.Second
+	watchBackoffMax = time.Minute
+)
+
+// Wait for d, or until ctx is done.
+func sleep(ctx context.Context, d time.Duration) error {
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-time.After(d):
+		return nil
+	}
+}
+
+// Watcher is a Lookable notified of the changes of its members, without waiting for the next lookup.
+type Watcher interface {
+	Lookable
+	// Watch calls changed whenever the members of the Lookable may have changed, until ctx is done
+	// or the watch fails.
+	Watch(ctx context.Context, clients *Clients, changed func()) error
+}
@@ -0,0 +1,205 @@
+package lookable
+
+// Lookables of custom inventories, whose members are given by a command or an HTTP endpoint
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/AirVantage/overlord/pkg/process"
+)
+
+// Default timeout of the plugin lookables.
+const pluginTimeout = 30 * time.Second
+
+// Maximum size of the members given by a plugin lookable.
+const pluginOutputLimit = 16 << 20
+
+// Exec is a Lookable command printing its members on its standard output, as a JSON list of
+// members given either as an IP or as an object with an ip, and an optional port and meta.
+// It is run with bash -c on every lookup, OVERLORD_IPV6 telling the IP version in its environment.
+type Exec struct {
+	// Name used in templates, the command by default
+	Name    string
+	Command string
+	// Maximum duration of the command, its process group is killed on expiry, 30s by default
+	Timeout time.Duration
+}
+
+func (e Exec) String() string {
+	if e.Name != "" {
+		return e.Name
+	}
+	return e.Command
+}
+
+// Validate the exec lookable configuration.
+func (e Exec) Validate() error {
+	if e.Command == "" {
+		return errors.New("exec requires a command")
+	}
+	return nil
+}
+
+// LookupMembers printed by the command. The command failing, or printing anything else than a
+// list of valid members, is an error. Members are given as is, whatever the IP version.
+func (e Exec) LookupMembers(ctx context.Context, clients *Clients, ipv6 bool) ([]Member, error) {
+	ctx, cancel := context.WithTimeout(ctx, timeoutOrDefault(e.Timeout))
+	defer cancel()
+
+	cmd := process.Command(ctx, "bash", "-c", e.Command)
+	cmd.Env = append(os.Environ(), "OVERLORD_IPV6="+strconv.FormatBool(ipv6))
+
+	stdout := &limitedWriter{limit: pluginOutputLimit}
+	var stderr bytes.Buffer
+	cmd.Stdout = stdout
+	cmd.Stderr = &stderr
+	err := cmd.Start()
+	if err == nil {
+		err = process.Wait(ctx, cmd)
+	}
+	if stdout.exceeded {
+		return nil, fmt.Errorf("exec %s: output larger than %d bytes", e, pluginOutputLimit)
+	}
+	if err != nil {
+		if ctx.Err() == context.DeadlineExceeded {
+			err = fmt.Errorf("timed out after %s: %w", timeoutOrDefault(e.Timeout), err)
+		}
+		if output := strings.TrimSpace(stderr.String()); output != "" {
+			err = fmt.Errorf("%w: %s", err, output)
+		}
+		return nil, fmt.Errorf("exec %s: %w", e, err)
+	}
+	return decodeMembers("exec "+e.String(), stdout.buf.Bytes())
+}
+
+// limitedWriter buffers at most limit bytes, the writes beyond failing so that the command
+// writing them gets a broken pipe rather than being read until it exits.
+type limitedWriter struct {
+	buf      bytes.Buffer
+	limit    int
+	exceeded bool
+}
+
+func (w *limitedWriter) Write(p []byte) (int, error) {
+	if w.buf.Len()+len(p) > w.limit {
+		w.exceeded = true
+		return 0, io.ErrShortWrite
+	}
+	return w.buf.Write(p)
+}
+
+// Implement public interface
+func (e Exec) LookupIPs(ctx context.Context, clients *Clients, ipv6 bool) ([]string, error) {
+	members, err := e.LookupMembers(ctx, clients, ipv6)
+	if err != nil {
+		return nil, err
+	}
+	return IPs(members), nil
+}
+
+// HTTP is a Lookable URL whose GET response lists its members, with the JSON schema of Exec.
+type HTTP struct {
+	// Name used in templates, the URL by default
+	Name string
+	URL  string
+	// Maximum duration of the request, 30s by default
+	Timeout time.Duration
+	// Optional file holding a bearer token, read on every lookup
+	TokenFile string `toml:"token_file"`
+}
+
+func (h HTTP) String() string {
+	if h.Name != "" {
+		return h.Name
+	}
+	return h.URL
+}
+
+// Validate the HTTP lookable configuration.
+func (h HTTP) Validate() error {
+	u, err := url.Parse(h.URL)
+	if err != nil {
+		return fmt.Errorf("invalid http url: %w", err)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return fmt.Errorf("unsupported http url %q", h.URL)
+	}
+	return nil
+}
+
+// LookupMembers of the response. A status other than 200, or a body which is not a list of
+// valid members, is an error. Members are given as is, whatever the IP version.
+func (h HTTP) LookupMembers(ctx context.Context, clients *Clients, ipv6 bool) ([]Member, error) {
+	ctx, cancel := context.WithTimeout(ctx, timeoutOrDefault(h.Timeout))
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, h.URL, nil)
+	if err != nil {
+		return nil, fmt.Errorf("http %s: %w", h, err)
+	}
+	req.Header.Set("Accept", "application/json")
+	if h.TokenFile != "" {
+		token, err := os.ReadFile(h.TokenFile)
+		if err != nil {
+			return nil, fmt.Errorf("http %s: %w", h, err)
+		}
+		req.Header.Set("Authorization", "Bearer "+strings.TrimSpace(string(token)))
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("http %s: %w", h, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("http %s: %s", h, resp.Status)
+	}
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, pluginOutputLimit+1))
+	if err != nil {
+		return nil, fmt.Errorf("http %s: %w", h, err)
+	}
+	if len(body) > pluginOutputLimit {
+		return nil, fmt.Errorf("http %s: body larger than %d bytes", h, pluginOutputLimit)
+	}
+	return decodeMembers("http "+h.String(), body)
+}
+
+// Implement public interface
+func (h HTTP) LookupIPs(ctx context.Context, clients *Clients, ipv6 bool) ([]string, error) {
+	members, err := h.LookupMembers(ctx, clients, ipv6)
+	if err != nil {
+		return nil, err
+	}
+	return IPs(members), nil
+}
+
+// Members of a JSON list, an empty list being required for no members.
+func decodeMembers(name string, content []byte) ([]Member, error) {
+	var members []Member
+	if err := json.Unmarshal(content, &members); err != nil {
+		return nil, fmt.Errorf("%s: %w", name, err)
+	}
+	if members == nil {
+		return nil, fmt.Errorf("%s: expect a list of members, got %s", name, bytes.TrimSpace(content))
+	}
+	return members, nil
+}
+
+func timeoutOrDefault(timeout time.Duration) time.Duration {
+	if timeout > 0 {
+		return timeout
+	}
+	return pluginTimeout
+}
@@ -0,0 +1,170 @@
+package lookable
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestExecLookupMembers(t *testing.T) {
+	cases := []struct {
+		exec   Exec
+		ipv6   bool
+		expect []Member
+		err    bool
+	}{
+		/* Members given as IPs or objects */
+		{
+			exec: Exec{Command: `echo '["10.0.0.1", {"ip": "10.0.0.2", "port": 8080, "meta": {"zone": "a"}}]'`},
+			expect: []Member{
+				{IP: "10.0.0.1"},
+				{IP: "10.0.0.2", Port: 8080, Meta: map[string]string{"zone": "a"}},
+			},
+		},
+		/* IP version in the environment */
+		{
+			exec:   Exec{Command: `[ "$OVERLORD_IPV6" = true ] && echo '["2001:db8::1"]'`},
+			ipv6:   true,
+			expect: []Member{{IP: "2001:db8::1"}},
+		},
+		/* No members */
+		{
+			exec:   Exec{Command: `echo '[]'`},
+			expect: []Member{},
+		},
+		/* Empty output */
+		{
+			exec: Exec{Command: `true`},
+			err:  true,
+		},
+		/* Invalid member */
+		{
+			exec: Exec{Command: `echo '[{"ip": "web-1"}]'`},
+			err:  true,
+		},
+		/* Failure */
+		{
+			exec: Exec{Command: `echo '["10.0.0.1"]'; exit 1`},
+			err:  true,
+		},
+		/* Output over the limit, interrupting the command */
+		{
+			exec: Exec{Command: `cat /dev/zero`, Timeout: 10 * time.Second},
+			err:  true,
+		},
+		/* Timeout, killing the children of the command */
+		{
+			exec: Exec{Command: `sleep 10 & wait`, Timeout: 100 * time.Millisecond},
+			err:  true,
+		},
+	}
+
+	for i, tt := range cases {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			members, err := tt.exec.LookupMembers(context.Background(), &Clients{}, tt.ipv6)
+			if tt.err {
+				if err == nil {
+					t.Errorf("expect an error, got %v", members)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(members, tt.expect) {
+				t.Errorf("expect %v, got %v", tt.expect, members)
+			}
+		})
+	}
+}
+
+func TestHTTPLookupMembers(t *testing.T) {
+	var authorization string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		authorization = req.Header.Get("Authorization")
+		switch req.URL.Path {
+		case "/members":
+			w.Write([]byte(`["10.0.0.1", {"ip": "10.0.0.2", "port": 8080, "meta": {"zone": "a"}}]`))
+		case "/slow":
+			time.Sleep(time.Second)
+			w.Write([]byte(`[]`))
+		case "/invalid":
+			w.Write([]byte(`{"members": []}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	tokenFile := filepath.Join(t.TempDir(), "token")
+	if err := os.WriteFile(tokenFile, []byte("secret\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		http          HTTP
+		expect        []Member
+		authorization string
+		err           bool
+	}{
+		/* Members given as IPs or objects */
+		{
+			http: HTTP{URL: server.URL + "/members"},
+			expect: []Member{
+				{IP: "10.0.0.1"},
+				{IP: "10.0.0.2", Port: 8080, Meta: map[string]string{"zone": "a"}},
+			},
+		},
+		/* Bearer token */
+		{
+			http: HTTP{URL: server.URL + "/members", TokenFile: tokenFile},
+			expect: []Member{
+				{IP: "10.0.0.1"},
+				{IP: "10.0.0.2", Port: 8080, Meta: map[string]string{"zone": "a"}},
+			},
+			authorization: "Bearer secret",
+		},
+		/* Not found */
+		{
+			http: HTTP{URL: server.URL + "/missing"},
+			err:  true,
+		},
+		/* Not a list */
+		{
+			http: HTTP{URL: server.URL + "/invalid"},
+			err:  true,
+		},
+		/* Timeout */
+		{
+			http: HTTP{URL: server.URL + "/slow", Timeout: 100 * time.Millisecond},
+			err:  true,
+		},
+	}
+
+	for i, tt := range cases {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			members, err := tt.http.LookupMembers(context.Background(), &Clients{}, false)
+			if tt.err {
+				if err == nil {
+					t.Errorf("expect an error, got %v", members)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(members, tt.expect) {
+				t.Errorf("expect %v, got %v", tt.expect, members)
+			}
+			if authorization != tt.authorization {
+				t.Errorf("expect %q, got %q", tt.authorization, authorization)
+			}
+		})
+	}
+}
@@ -54,25 +54,10 @@ func (s Subnet) doLookupIPs(api EC2API, ctx context.Context, ipv6 bool) ([]strin
 		},
 	}
 
-	resp2, err := api.DescribeInstances(ctx, params2)
-	if err != nil {
-		return nil, err
-	}
-
-	for _, reservation := range resp2.Reservations {
-		for _, instance := range reservation.Instances {
-			if ipv6 {
-				output = append(output, *instance.Ipv6Address)
-			} else {
-				output = append(output, *instance.PrivateIpAddress)
-			}
-		}
-	}
-
-	return output, nil
+	return describeInstancesIPs(ctx, api, params2, ipv6)
 }
 
 // Implement public interface
-func (s Subnet) LookupIPs(ctx context.Context, cfg aws.Config, ipv6 bool) ([]string, error) {
-	return s.doLookupIPs(ec2.NewFromConfig(cfg), ctx, ipv6)
+func (s Subnet) LookupIPs(ctx context.Context, clients *Clients, ipv6 bool) ([]string, error) {
+	return s.doLookupIPs(clients.EC2, ctx, ipv6)
 }
@@ -19,8 +19,6 @@ func (t Tag) String() string {
 // LookupIPs of all the instances named with the given tag.
 func (t Tag) doLookupIPs(api EC2API, ctx context.Context, ipv6 bool) ([]string, error) {
 
-	var output []string
-
 	params := &ec2.DescribeInstancesInput{
 		Filters: []types.Filter{
 			{
@@ -34,25 +32,10 @@ func (t Tag) doLookupIPs(api EC2API, ctx context.Context, ipv6 bool) ([]string,
 		},
 	}
 
-	resp, err := api.DescribeInstances(ctx, params)
-	if err != nil {
-		return nil, err
-	}
-
-	for _, reservation := range resp.Reservations {
-		for _, instance := range reservation.Instances {
-			if ipv6 {
-				output = append(output, *instance.Ipv6Address)
-			} else {
-				output = append(output, *instance.PrivateIpAddress)
-			}
-		}
-	}
-
-	return output, nil
+	return describeInstancesIPs(ctx, api, params, ipv6)
 }
 
 // LookupIPs of all the instances named with the given tag.
-func (t Tag) LookupIPs(ctx context.Context, cfg aws.Config, ipv6 bool) ([]string, error) {
-	return t.doLookupIPs(ec2.NewFromConfig(cfg), ctx, ipv6)
+func (t Tag) LookupIPs(ctx context.Context, clients *Clients, ipv6 bool) ([]string, error) {
+	return t.doLookupIPs(clients.EC2, ctx, ipv6)
 }
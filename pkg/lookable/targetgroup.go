@@ -0,0 +1,149 @@
+package lookable
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	"github.com/aws/aws-sdk-go-v2/service/elasticloadbalancingv2"
+	elbtypes "github.com/aws/aws-sdk-go-v2/service/elasticloadbalancingv2/types"
+)
+
+// Health states of the targets of a TargetGroup by default.
+var DefaultHealthStates = NewList(string(elbtypes.TargetHealthStateEnumHealthy))
+
+// TargetGroup is a Lookable ELBv2 target group, whose members are its registered targets.
+type TargetGroup struct {
+	// ARN or name of the target group
+	Name string
+	// Health states of the targets to keep, healthy by default
+	HealthStates List `toml:"health_states"`
+}
+
+func (g TargetGroup) String() string {
+	return g.Name
+}
+
+// Validate the target group configuration.
+func (g TargetGroup) Validate() error {
+	if g.Name == "" {
+		return errors.New("target group requires a name")
+	}
+	for _, state := range g.HealthStates.Values() {
+		if !slices.Contains(elbtypes.TargetHealthStateEnum("").Values(), elbtypes.TargetHealthStateEnum(state)) {
+			return fmt.Errorf("unsupported health state %s of target group %s", state, g.Name)
+		}
+	}
+	return nil
+}
+
+// LookupMembers of the targets in the configured health states. Members have the port of their target,
+// its health state, id and availability zone in their metadata. Instance targets are resolved to
+// their private IP, IP targets are given as is.
+func (g TargetGroup) doLookupMembers(elbAPI ELBV2API, ec2API EC2API, ctx context.Context, ipv6 bool) ([]Member, error) {
+	arn := g.Name
+	if !strings.HasPrefix(arn, "arn:") {
+		resp, err := elbAPI.DescribeTargetGroups(ctx, &elasticloadbalancingv2.DescribeTargetGroupsInput{Names: []string{g.Name}})
+		if err != nil {
+			return nil, err
+		}
+		if len(resp.TargetGroups) == 0 {
+			return nil, fmt.Errorf("target group %s not found", g.Name)
+		}
+		arn = *resp.TargetGroups[0].TargetGroupArn
+	}
+
+	resp, err := elbAPI.DescribeTargetHealth(ctx, &elasticloadbalancingv2.DescribeTargetHealthInput{TargetGroupArn: aws.String(arn)})
+	if err != nil {
+		return nil, err
+	}
+
+	states := g.HealthStates
+	if states == "" {
+		states = DefaultHealthStates
+	}
+
+	members := []Member{}
+	var instanceIDs []string
+	for _, description := range resp.TargetHealthDescriptions {
+		if description.Target == nil || description.TargetHealth == nil || !states.Has(string(description.TargetHealth.State)) {
+			continue
+		}
+		target := description.Target
+		id := aws.ToString(target.Id)
+		// Lambda functions have no IP
+		if strings.HasPrefix(id, "arn:") {
+			continue
+		}
+
+		member := Member{
+			IP:   id,
+			Port: int(aws.ToInt32(target.Port)),
+			Meta: map[string]string{
+				"id":    id,
+				"state": string(description.TargetHealth.State),
+			},
+		}
+		if target.AvailabilityZone != nil {
+			member.Meta["availability_zone"] = *target.AvailabilityZone
+		}
+		if strings.HasPrefix(id, "i-") {
+			instanceIDs = append(instanceIDs, id)
+		}
+		members = append(members, member)
+	}
+
+	if len(instanceIDs) == 0 {
+		return members, nil
+	}
+
+	// Resolve instance targets to their IP
+	ips := make(map[string]string)
+	paginator := ec2.NewDescribeInstancesPaginator(ec2API, &ec2.DescribeInstancesInput{InstanceIds: instanceIDs})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, err
+		}
+		for _, reservation := range page.Reservations {
+			for _, instance := range reservation.Instances {
+				if ipv6 && instance.Ipv6Address != nil {
+					ips[*instance.InstanceId] = *instance.Ipv6Address
+				} else if !ipv6 && instance.PrivateIpAddress != nil {
+					ips[*instance.InstanceId] = *instance.PrivateIpAddress
+				}
+			}
+		}
+	}
+
+	resolved := members[:0]
+	for _, member := range members {
+		if strings.HasPrefix(member.IP, "i-") {
+			ip, exists := ips[member.IP]
+			if !exists {
+				continue
+			}
+			member.IP = ip
+		}
+		resolved = append(resolved, member)
+	}
+	return resolved, nil
+}
+
+// LookupMembers of the targets in the configured health states.
+func (g TargetGroup) LookupMembers(ctx context.Context, clients *Clients, ipv6 bool) ([]Member, error) {
+	return g.doLookupMembers(clients.ELBV2, clients.EC2, ctx, ipv6)
+}
+
+// Implement public interface
+func (g TargetGroup) LookupIPs(ctx context.Context, clients *Clients, ipv6 bool) ([]string, error) {
+	members, err := g.LookupMembers(ctx, clients, ipv6)
+	if err != nil {
+		return nil, err
+	}
+	return IPs(members), nil
+}
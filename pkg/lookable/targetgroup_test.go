@@ -0,0 +1,164 @@
+package lookable
+
+import (
+	"context"
+	"reflect"
+	"strconv"
+	"testing"
+
+	"github.com/BurntSushi/toml"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	ec2types "github.com/aws/aws-sdk-go-v2/service/ec2/types"
+	"github.com/aws/aws-sdk-go-v2/service/elasticloadbalancingv2"
+	elbtypes "github.com/aws/aws-sdk-go-v2/service/elasticloadbalancingv2/types"
+)
+
+const targetGroupARN = "arn:aws:elasticloadbalancing:eu-west-1:123456789012:targetgroup/web/0123456789abcdef"
+
+func target(id string, port int32, state elbtypes.TargetHealthStateEnum) elbtypes.TargetHealthDescription {
+	return elbtypes.TargetHealthDescription{
+		Target:       &elbtypes.TargetDescription{Id: aws.String(id), Port: aws.Int32(port), AvailabilityZone: aws.String("eu-west-1a")},
+		TargetHealth: &elbtypes.TargetHealth{State: state},
+	}
+}
+
+func TestTargetGroupLookupMembers(t *testing.T) {
+	targets := []elbtypes.TargetHealthDescription{
+		target("10.0.0.1", 8080, elbtypes.TargetHealthStateEnumHealthy),
+		target("10.0.0.2", 8080, elbtypes.TargetHealthStateEnumDraining),
+		target("i-1", 9090, elbtypes.TargetHealthStateEnumHealthy),
+		target("i-2", 9090, elbtypes.TargetHealthStateEnumUnhealthy),
+		target("arn:aws:lambda:eu-west-1:123456789012:function:web", 0, elbtypes.TargetHealthStateEnumHealthy),
+	}
+
+	cases := []struct {
+		group  TargetGroup
+		ipv6   bool
+		expect []Member
+		err    bool
+	}{
+		/* Healthy targets by ARN, instances resolved */
+		{
+			group: TargetGroup{Name: targetGroupARN},
+			expect: []Member{
+				{IP: "10.0.0.1", Port: 8080, Meta: map[string]string{"id": "10.0.0.1", "state": "healthy", "availability_zone": "eu-west-1a"}},
+				{IP: "10.0.1.1", Port: 9090, Meta: map[string]string{"id": "i-1", "state": "healthy", "availability_zone": "eu-west-1a"}},
+			},
+		},
+		/* Healthy and draining targets by name, in IPv6 */
+		{
+			group: TargetGroup{Name: "web", HealthStates: NewList("healthy", "draining")},
+			ipv6:  true,
+			expect: []Member{
+				{IP: "10.0.0.1", Port: 8080, Meta: map[string]string{"id": "10.0.0.1", "state": "healthy", "availability_zone": "eu-west-1a"}},
+				{IP: "10.0.0.2", Port: 8080, Meta: map[string]string{"id": "10.0.0.2", "state": "draining", "availability_zone": "eu-west-1a"}},
+				{IP: "2001:db8::1", Port: 9090, Meta: map[string]string{"id": "i-1", "state": "healthy", "availability_zone": "eu-west-1a"}},
+			},
+		},
+		/* Unknown name */
+		{
+			group: TargetGroup{Name: "api"},
+			err:   true,
+		},
+	}
+
+	elbAPI := &MockELBV2API{
+		DescribeTargetGroupsMethod: func(ctx context.Context, params *elasticloadbalancingv2.DescribeTargetGroupsInput, optFns ...func(*elasticloadbalancingv2.Options)) (*elasticloadbalancingv2.DescribeTargetGroupsOutput, error) {
+			if Equal(params.Names, []string{"web"}) {
+				return &elasticloadbalancingv2.DescribeTargetGroupsOutput{TargetGroups: []elbtypes.TargetGroup{{TargetGroupArn: aws.String(targetGroupARN)}}}, nil
+			}
+			return &elasticloadbalancingv2.DescribeTargetGroupsOutput{}, nil
+		},
+		DescribeTargetHealthMethod: func(ctx context.Context, params *elasticloadbalancingv2.DescribeTargetHealthInput, optFns ...func(*elasticloadbalancingv2.Options)) (*elasticloadbalancingv2.DescribeTargetHealthOutput, error) {
+			if *params.TargetGroupArn != targetGroupARN {
+				t.Fatalf("expect %s, got %s", targetGroupARN, *params.TargetGroupArn)
+			}
+			return &elasticloadbalancingv2.DescribeTargetHealthOutput{TargetHealthDescriptions: targets}, nil
+		},
+	}
+	ec2API := &MockEC2API{
+		DescribeInstancesMethod: func(ctx context.Context, params *ec2.DescribeInstancesInput, optFns ...func(*ec2.Options)) (*ec2.DescribeInstancesOutput, error) {
+			var instances []ec2types.Instance
+			for _, id := range params.InstanceIds {
+				if id == "i-1" {
+					instances = append(instances, ec2types.Instance{InstanceId: aws.String("i-1"), PrivateIpAddress: aws.String("10.0.1.1"), Ipv6Address: aws.String("2001:db8::1")})
+				}
+			}
+			return &ec2.DescribeInstancesOutput{Reservations: []ec2types.Reservation{{Instances: instances}}}, nil
+		},
+	}
+
+	for i, tt := range cases {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			members, err := tt.group.doLookupMembers(elbAPI, ec2API, context.Background(), tt.ipv6)
+			if tt.err {
+				if err == nil {
+					t.Errorf("expect an error, got %v", members)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(members, tt.expect) {
+				t.Errorf("expect %v, got %v", tt.expect, members)
+			}
+		})
+	}
+}
+
+func TestTargetGroupConfig(t *testing.T) {
+
+	cases := []struct {
+		config string
+		expect TargetGroup
+		err    bool
+	}{
+		/* Default health states */
+		{
+			config: `name = "web"`,
+			expect: TargetGroup{Name: "web"},
+		},
+		/* Health states sorted */
+		{
+			config: `name = "web"
+health_states = ["unused", "healthy"]`,
+			expect: TargetGroup{Name: "web", HealthStates: "healthy,unused"},
+		},
+		/* Unknown health state */
+		{
+			config: `name = "web"
+health_states = ["sick"]`,
+			err: true,
+		},
+		/* Health states not in an array */
+		{
+			config: `name = "web"
+health_states = "healthy"`,
+			err: true,
+		},
+	}
+
+	for i, tt := range cases {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			var group TargetGroup
+			_, err := toml.Decode(tt.config, &group)
+			if err == nil {
+				err = group.Validate()
+			}
+			if tt.err {
+				if err == nil {
+					t.Errorf("expect an error, got %v", group)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if group != tt.expect {
+				t.Errorf("expect %v, got %v", tt.expect, group)
+			}
+		})
+	}
+}
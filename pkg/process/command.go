@@ -0,0 +1,45 @@
+package process
+
+// Run commands in their own process group, killed as a whole
+
+import (
+	"context"
+	"errors"
+	"os/exec"
+	"syscall"
+	"time"
+)
+
+// Delay given to the output streams of a command to be closed after it exited,
+// in case it left a background process holding them.
+const waitDelay = time.Second
+
+// Command returns the command name run with args in its own process group,
+// so that the command and all its children are killed when ctx expires.
+func Command(ctx context.Context, name string, args ...string) *exec.Cmd {
+	cmd := exec.CommandContext(ctx, name, args...)
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	cmd.Cancel = func() error {
+		return syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+	}
+	cmd.WaitDelay = waitDelay
+	return cmd
+}
+
+// Wait for a started command of Command to exit. A process left holding its output is
+// not an error, unless ctx expired: the processes left in its group are then killed.
+func Wait(ctx context.Context, cmd *exec.Cmd) error {
+	err := cmd.Wait()
+	if errors.Is(err, exec.ErrWaitDelay) {
+		// The command succeeded but left a process attached to its output
+		err = nil
+	}
+	if ctx.Err() != nil {
+		// Cancel is not called once the command exited, children it left in its group are killed here
+		syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+		if err == nil {
+			err = ctx.Err()
+		}
+	}
+	return err
+}
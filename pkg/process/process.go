@@ -0,0 +1,132 @@
+package process
+
+// Find processes by pid file or name and send them signals
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// Root of the proc filesystem, used to find processes by name
+const procRoot = "/proc"
+
+// Signals accepted by name, with or without the SIG prefix.
+var signals = map[string]syscall.Signal{
+	"HUP":   syscall.SIGHUP,
+	"INT":   syscall.SIGINT,
+	"QUIT":  syscall.SIGQUIT,
+	"USR1":  syscall.SIGUSR1,
+	"USR2":  syscall.SIGUSR2,
+	"TERM":  syscall.SIGTERM,
+	"CONT":  syscall.SIGCONT,
+	"WINCH": syscall.SIGWINCH,
+}
+
+// ErrNotFound is returned when no process matches the given name.
+var ErrNotFound = errors.New("no matching process")
+
+// StalePidFileError is returned when a pid file refers to a process which is not running.
+type StalePidFileError struct {
+	PidFile string
+	Pid     int
+}
+
+func (e *StalePidFileError) Error() string {
+	return fmt.Sprintf("stale pid file %s: process %d is not running", e.PidFile, e.Pid)
+}
+
+// ParseSignal returns the signal for a name such as "HUP" or "SIGHUP".
+func ParseSignal(name string) (syscall.Signal, error) {
+	sig, exists := signals[strings.TrimPrefix(strings.ToUpper(name), "SIG")]
+	if !exists {
+		return 0, fmt.Errorf("unsupported signal %s", name)
+	}
+	return sig, nil
+}
+
+// ReadPidFile returns the pid stored in pidFile, checking that the process is running.
+func ReadPidFile(pidFile string) (int, error) {
+	content, err := os.ReadFile(pidFile)
+	if err != nil {
+		return 0, err
+	}
+
+	// Some daemons write one pid per line, the first one is the main process
+	fields := strings.Fields(string(content))
+	if len(fields) == 0 {
+		return 0, fmt.Errorf("empty pid file %s", pidFile)
+	}
+	pid, err := strconv.Atoi(fields[0])
+	if err != nil || pid <= 0 {
+		return 0, fmt.Errorf("invalid pid file %s: %q", pidFile, fields[0])
+	}
+
+	if !running(pid) {
+		return 0, &StalePidFileError{PidFile: pidFile, Pid: pid}
+	}
+	return pid, nil
+}
+
+// FindByName returns the pids of the processes whose command name is name.
+func FindByName(name string) ([]int, error) {
+	entries, err := os.ReadDir(procRoot)
+	if err != nil {
+		return nil, fmt.Errorf("unable to list processes: %w", err)
+	}
+
+	var pids []int
+	for _, entry := range entries {
+		pid, err := strconv.Atoi(entry.Name())
+		if err != nil || pid == os.Getpid() {
+			continue
+		}
+		comm, err := os.ReadFile(filepath.Join(procRoot, entry.Name(), "comm"))
+		if err != nil {
+			continue
+		}
+		if strings.TrimSpace(string(comm)) == name {
+			pids = append(pids, pid)
+		}
+	}
+
+	if len(pids) == 0 {
+		return nil, fmt.Errorf("%w: %s", ErrNotFound, name)
+	}
+	return pids, nil
+}
+
+// Signal sends sig to the process of pidFile, or to all the processes named name
+// when pidFile is empty. Returns the signaled pids.
+func Signal(pidFile, name string, sig syscall.Signal) ([]int, error) {
+	var pids []int
+	if pidFile != "" {
+		pid, err := ReadPidFile(pidFile)
+		if err != nil {
+			return nil, err
+		}
+		pids = []int{pid}
+	} else {
+		var err error
+		if pids, err = FindByName(name); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, pid := range pids {
+		if err := syscall.Kill(pid, sig); err != nil {
+			return nil, fmt.Errorf("unable to signal process %d: %w", pid, err)
+		}
+	}
+	return pids, nil
+}
+
+// Tells whether a process exists, a permission error means it exists but belongs to another user.
+func running(pid int) bool {
+	err := syscall.Kill(pid, 0)
+	return err == nil || errors.Is(err, syscall.EPERM)
+}
@@ -0,0 +1,93 @@
+package process
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"syscall"
+	"testing"
+)
+
+func TestParseSignal(t *testing.T) {
+
+	cases := []struct {
+		name   string
+		expect syscall.Signal
+		err    bool
+	}{
+		{name: "HUP", expect: syscall.SIGHUP},
+		{name: "SIGUSR2", expect: syscall.SIGUSR2},
+		{name: "sigterm", expect: syscall.SIGTERM},
+		{name: "KILL", err: true},
+	}
+
+	for i, tt := range cases {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			sig, err := ParseSignal(tt.name)
+			if (err != nil) != tt.err {
+				t.Fatalf("expect error %v, got %v", tt.err, err)
+			}
+			if sig != tt.expect {
+				t.Errorf("expect %v, got %v", tt.expect, sig)
+			}
+		})
+	}
+}
+
+func TestSignal(t *testing.T) {
+	cmd := exec.Command("sleep", "30")
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer cmd.Process.Kill()
+
+	// A process which exited, its pid is not running anymore
+	done := exec.Command("true")
+	if err := done.Run(); err != nil {
+		t.Fatal(err)
+	}
+
+	tmp := t.TempDir()
+	pidFile := filepath.Join(tmp, "sleep.pid")
+	if err := os.WriteFile(pidFile, []byte(strconv.Itoa(cmd.Process.Pid)+"\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	stalePidFile := filepath.Join(tmp, "stale.pid")
+	if err := os.WriteFile(stalePidFile, []byte(strconv.Itoa(done.Process.Pid)), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	/* Stale pid file */
+	_, err := Signal(stalePidFile, "", syscall.SIGTERM)
+	var stale *StalePidFileError
+	if !errors.As(err, &stale) || stale.Pid != done.Process.Pid {
+		t.Errorf("expect stale pid file error, got %v", err)
+	}
+
+	/* Missing pid file */
+	if _, err := Signal(filepath.Join(tmp, "missing.pid"), "", syscall.SIGTERM); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("expect not exist error, got %v", err)
+	}
+
+	/* Unknown process name */
+	if _, err := Signal("", "overlord-missing-process", syscall.SIGTERM); !errors.Is(err, ErrNotFound) {
+		t.Errorf("expect not found error, got %v", err)
+	}
+
+	/* Signal through the pid file */
+	pids, err := Signal(pidFile, "", syscall.SIGTERM)
+	if err != nil {
+		t.Fatalf("expect no error, got %v", err)
+	}
+	if len(pids) != 1 || pids[0] != cmd.Process.Pid {
+		t.Errorf("expect [%d], got %v", cmd.Process.Pid, pids)
+	}
+
+	err = cmd.Wait()
+	var exitErr *exec.ExitError
+	if !errors.As(err, &exitErr) || exitErr.Sys().(syscall.WaitStatus).Signal() != syscall.SIGTERM {
+		t.Errorf("expect process terminated by SIGTERM, got %v", err)
+	}
+}
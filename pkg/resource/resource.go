@@ -3,9 +3,18 @@ package resource
 // Configuration file structure
 
 import (
+	"errors"
+	"fmt"
 	"os"
+	"strings"
+	"time"
+	"unicode"
 
+	"github.com/AirVantage/overlord/pkg/haproxy"
 	"github.com/AirVantage/overlord/pkg/lookable"
+	"github.com/AirVantage/overlord/pkg/process"
+	"github.com/AirVantage/overlord/pkg/systemd"
+	"github.com/AirVantage/overlord/pkg/verify"
 )
 
 // ResourceConfig map the toml configuration file
@@ -15,11 +24,268 @@ type ResourceConfig struct {
 
 // Resource represents a template resource that needs to be managed
 type Resource struct {
-	Src       string
-	Dest      string
-	Groups    []lookable.AutoScalingGroup
-	Tags      []lookable.Tag
-	Subnets   []lookable.Subnet
-	ReloadCmd string `toml:"reload_cmd"`
+	Src     string
+	Dest    string
+	Groups  []lookable.AutoScalingGroup
+	Tags    []lookable.Tag
+	Subnets []lookable.Subnet
+	// Local files, or directories of files, listing members
+	Files []lookable.File
+	// DNS names, resolved to the addresses of their A, AAAA or SRV records
+	DNS []lookable.DNS `toml:"dns"`
+	// ELBv2 target groups, by ARN or name
+	TargetGroups []lookable.TargetGroup `toml:"target_groups"`
+	// ECS services or task families, using the awsvpc network mode
+	ECSServices []lookable.ECSService `toml:"ecs_services"`
+	// AWS Cloud Map services
+	CloudMap []lookable.CloudMapService `toml:"cloud_map"`
+	// Consul catalog services, watched with blocking queries
+	Consul []lookable.ConsulService `toml:"consul"`
+	// Kubernetes services, from their EndpointSlices
+	Kubernetes []lookable.KubernetesService `toml:"kubernetes"`
+	// Containers of the local Docker daemon, by labels
+	Docker []lookable.DockerContainers `toml:"docker"`
+	// Commands and URLs of custom inventories, giving JSON members
+	Exec      []lookable.Exec `toml:"exec"`
+	HTTP      []lookable.HTTP `toml:"http"`
+	ReloadCmd string          `toml:"reload_cmd"`
+	// Reload command as an argument list, run without a shell
+	ReloadArgv []string `toml:"reload_argv"`
+	// Maximum duration of the reload command, its process group is killed on expiry
+	ReloadTimeout time.Duration `toml:"reload_timeout"`
+	// User, working directory and additional environment of the reload command
+	ReloadUser string            `toml:"reload_user"`
+	ReloadDir  string            `toml:"reload_dir"`
+	ReloadEnv  map[string]string `toml:"reload_env"`
+	// Signal sent instead of running a reload command, to the process of PidFile or named ProcessName
+	ReloadSignal string `toml:"reload_signal"`
+	PidFile      string `toml:"pid_file"`
+	ProcessName  string `toml:"process_name"`
+	// systemd unit reloaded over D-Bus instead of running a reload command, reload_unit_action defaults to reload
+	ReloadUnit       string `toml:"reload_unit"`
+	ReloadUnitAction string `toml:"reload_unit_action"`
+	// Resources of the same reload group, or with identical reload commands, are reloaded once per iteration
+	ReloadGroup string `toml:"reload_group"`
+	// Number of previous versions of Dest to keep, enables rollback on reload failure
+	Backups int `toml:"backups"`
+	// Optional health probe run after the reload command
+	Verify *verify.Probe `toml:"verify"`
+	// Optional HAProxy Runtime API, used instead of the reload command when only IPs changed
+	HAProxy   *haproxy.Runtime `toml:"haproxy"`
 	SrcFSInfo os.FileInfo
 }
+
+// Validate the resource configuration.
+func (r *Resource) Validate() error {
+	if (r.Src == "") != (r.Dest == "") {
+		return errors.New("src and dest must be set together")
+	}
+	for _, dns := range r.DNS {
+		if err := dns.Validate(); err != nil {
+			return err
+		}
+	}
+	for _, group := range r.TargetGroups {
+		if err := group.Validate(); err != nil {
+			return err
+		}
+	}
+	for _, service := range r.ECSServices {
+		if err := service.Validate(); err != nil {
+			return err
+		}
+	}
+	for _, service := range r.CloudMap {
+		if err := service.Validate(); err != nil {
+			return err
+		}
+	}
+	for _, service := range r.Consul {
+		if err := service.Validate(); err != nil {
+			return err
+		}
+	}
+	for _, service := range r.Kubernetes {
+		if err := service.Validate(); err != nil {
+			return err
+		}
+	}
+	for _, containers := range r.Docker {
+		if err := containers.Validate(); err != nil {
+			return err
+		}
+	}
+	for _, plugin := range r.Exec {
+		if err := plugin.Validate(); err != nil {
+			return err
+		}
+	}
+	for _, plugin := range r.HTTP {
+		if err := plugin.Validate(); err != nil {
+			return err
+		}
+	}
+	if err := lookable.CheckNames(r.Lookables()); err != nil {
+		return err
+	}
+	if err := CheckEnvSuffixes(r.Lookables()); err != nil {
+		return err
+	}
+	if !r.HasTemplate() && (r.HasReload() || r.HAProxy != nil) {
+		return errors.New("a reload requires a template")
+	}
+	if r.ReloadCmd != "" && len(r.ReloadArgv) > 0 {
+		return errors.New("reload_cmd and reload_argv are mutually exclusive")
+	}
+	if r.ReloadSignal != "" {
+		if r.ReloadCmd != "" || len(r.ReloadArgv) > 0 {
+			return errors.New("reload_signal and reload command are mutually exclusive")
+		}
+		if (r.PidFile == "") == (r.ProcessName == "") {
+			return errors.New("reload_signal requires either pid_file or process_name")
+		}
+		if _, err := process.ParseSignal(r.ReloadSignal); err != nil {
+			return err
+		}
+	}
+	if r.ReloadUnit != "" {
+		if r.ReloadCmd != "" || len(r.ReloadArgv) > 0 || r.ReloadSignal != "" {
+			return errors.New("reload_unit, reload_signal and reload command are mutually exclusive")
+		}
+		if !systemd.ValidAction(r.UnitAction()) {
+			return fmt.Errorf("unsupported reload_unit_action %s", r.ReloadUnitAction)
+		}
+	} else if r.ReloadUnitAction != "" {
+		return errors.New("reload_unit_action requires reload_unit")
+	}
+	if r.Verify != nil {
+		if err := r.Verify.Validate(); err != nil {
+			return err
+		}
+	}
+
+	if r.HAProxy != nil {
+		if !r.HasReload() {
+			return errors.New("haproxy requires a reload command")
+		}
+		if r.HAProxy.Socket == "" {
+			return errors.New("haproxy requires a socket")
+		}
+		watched := make(map[string]bool)
+		for _, l := range r.Lookables() {
+			watched[l.String()] = true
+		}
+		for name := range r.HAProxy.Backends {
+			if !watched[name] {
+				return fmt.Errorf("haproxy backend for %s which is not watched by the resource", name)
+			}
+		}
+	}
+
+	return nil
+}
+
+// EnvSuffix formats a lookable name as an environment variable suffix, e.g. "my-asg" gives "MY_ASG".
+func EnvSuffix(name string) string {
+	return strings.Map(func(r rune) rune {
+		if r > unicode.MaxASCII || !(unicode.IsLetter(r) || unicode.IsDigit(r)) {
+			return '_'
+		}
+		return unicode.ToUpper(r)
+	}, name)
+}
+
+// CheckEnvSuffixes returns an error when lookables of different names share the same environment
+// variable suffix, as the variables of one would overwrite the ones of the other.
+func CheckEnvSuffixes(lookables []lookable.Lookable) error {
+	names := make(map[string]string)
+	for _, l := range lookables {
+		suffix := EnvSuffix(l.String())
+		if other, exists := names[suffix]; exists && other != l.String() {
+			return fmt.Errorf("lookables %s and %s share the environment variable suffix %s", other, l, suffix)
+		}
+		names[suffix] = l.String()
+	}
+	return nil
+}
+
+// Lookables returns all the groups of instances watched by the resource.
+func (r *Resource) Lookables() []lookable.Lookable {
+	lookables := make([]lookable.Lookable, 0, len(r.Groups)+len(r.Tags)+len(r.Subnets)+len(r.Files)+len(r.DNS)+len(r.TargetGroups)+len(r.ECSServices)+len(r.CloudMap)+len(r.Consul)+len(r.Kubernetes)+len(r.Docker)+len(r.Exec)+len(r.HTTP))
+	for _, group := range r.Groups {
+		lookables = append(lookables, group)
+	}
+	for _, tag := range r.Tags {
+		lookables = append(lookables, tag)
+	}
+	for _, subnet := range r.Subnets {
+		lookables = append(lookables, subnet)
+	}
+	for _, file := range r.Files {
+		lookables = append(lookables, file)
+	}
+	for _, dns := range r.DNS {
+		lookables = append(lookables, dns)
+	}
+	for _, group := range r.TargetGroups {
+		lookables = append(lookables, group)
+	}
+	for _, service := range r.ECSServices {
+		lookables = append(lookables, service)
+	}
+	for _, service := range r.CloudMap {
+		lookables = append(lookables, service)
+	}
+	for _, service := range r.Consul {
+		lookables = append(lookables, service)
+	}
+	for _, service := range r.Kubernetes {
+		lookables = append(lookables, service)
+	}
+	for _, containers := range r.Docker {
+		lookables = append(lookables, containers)
+	}
+	for _, plugin := range r.Exec {
+		lookables = append(lookables, plugin)
+	}
+	for _, plugin := range r.HTTP {
+		lookables = append(lookables, plugin)
+	}
+	return lookables
+}
+
+// HasTemplate tells whether the resource generates a file from a template.
+// Resources without template only declare the lookables to watch.
+func (r *Resource) HasTemplate() bool {
+	return r.Src != ""
+}
+
+// HasReload tells whether a reload command is configured for the resource.
+func (r *Resource) HasReload() bool {
+	return r.ReloadCmd != "" || len(r.ReloadArgv) > 0 || r.ReloadSignal != "" || r.ReloadUnit != ""
+}
+
+// UnitAction returns the action run on the reload unit.
+func (r *Resource) UnitAction() string {
+	if r.ReloadUnitAction == "" {
+		return systemd.Reload
+	}
+	return r.ReloadUnitAction
+}
+
+// Command returns the reload command of the resource, for display purpose.
+func (r *Resource) Command() string {
+	if r.ReloadUnit != "" {
+		return "systemd " + r.UnitAction() + " " + r.ReloadUnit
+	}
+	if r.ReloadSignal != "" {
+		if r.PidFile != "" {
+			return "signal " + r.ReloadSignal + " pid_file " + r.PidFile
+		}
+		return "signal " + r.ReloadSignal + " process_name " + r.ProcessName
+	}
+	if len(r.ReloadArgv) > 0 {
+		return strings.Join(r.ReloadArgv, " ")
+	}
+	return r.ReloadCmd
+}
@@ -0,0 +1,67 @@
+package sdnotify
+
+// systemd notification protocol, see sd_notify(3)
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"time"
+)
+
+// Notification states
+const (
+	Ready    = "READY=1"
+	Stopping = "STOPPING=1"
+	Watchdog = "WATCHDOG=1"
+)
+
+// Status formats a status notification.
+func Status(status string) string {
+	return "STATUS=" + status
+}
+
+// Notify sends states to systemd, one per line, through the $NOTIFY_SOCKET datagram socket.
+// Returns false, without error, when not run by systemd with notifications enabled.
+func Notify(states ...string) (bool, error) {
+	socket := os.Getenv("NOTIFY_SOCKET")
+	if socket == "" {
+		return false, nil
+	}
+
+	// A leading @ denotes an abstract socket, which net handles the same way
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: socket, Net: "unixgram"})
+	if err != nil {
+		return false, err
+	}
+	defer conn.Close()
+
+	message := ""
+	for _, state := range states {
+		message += state + "\n"
+	}
+	if _, err := conn.Write([]byte(message)); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// WatchdogInterval returns the watchdog timeout configured by systemd, or 0 when the
+// watchdog is disabled or meant for another process. Pings must be sent more often.
+func WatchdogInterval() (time.Duration, error) {
+	usec := os.Getenv("WATCHDOG_USEC")
+	if usec == "" {
+		return 0, nil
+	}
+
+	if pid := os.Getenv("WATCHDOG_PID"); pid != "" && pid != strconv.Itoa(os.Getpid()) {
+		return 0, nil
+	}
+
+	interval, err := strconv.ParseInt(usec, 10, 64)
+	if err != nil || interval <= 0 {
+		return 0, fmt.Errorf("invalid WATCHDOG_USEC %q", usec)
+	}
+	return time.Duration(interval) * time.Microsecond, nil
+}
@@ -0,0 +1,76 @@
+package sdnotify
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestNotify(t *testing.T) {
+	t.Setenv("NOTIFY_SOCKET", "")
+	if sent, err := Notify(Ready); sent || err != nil {
+		t.Errorf("expect nothing sent without socket, got %v %v", sent, err)
+	}
+
+	socket := filepath.Join(t.TempDir(), "notify.sock")
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: socket, Net: "unixgram"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	t.Setenv("NOTIFY_SOCKET", socket)
+	sent, err := Notify(Ready, Status("2 groups"))
+	if !sent || err != nil {
+		t.Fatalf("expect notification sent, got %v %v", sent, err)
+	}
+
+	buffer := make([]byte, 1024)
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	n, err := conn.Read(buffer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expect := "READY=1\nSTATUS=2 groups\n"; string(buffer[:n]) != expect {
+		t.Errorf("expect %q, got %q", expect, string(buffer[:n]))
+	}
+}
+
+func TestWatchdogInterval(t *testing.T) {
+
+	cases := []struct {
+		usec   string
+		pid    string
+		expect time.Duration
+		err    bool
+	}{
+		/* Watchdog disabled */
+		{usec: "", expect: 0},
+		/* Watchdog enabled */
+		{usec: "30000000", expect: 30 * time.Second},
+		/* Watchdog for our process */
+		{usec: "30000000", pid: strconv.Itoa(os.Getpid()), expect: 30 * time.Second},
+		/* Watchdog for another process */
+		{usec: "30000000", pid: "1", expect: 0},
+		/* Invalid value */
+		{usec: "soon", err: true},
+	}
+
+	for i, tt := range cases {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			t.Setenv("WATCHDOG_USEC", tt.usec)
+			t.Setenv("WATCHDOG_PID", tt.pid)
+
+			interval, err := WatchdogInterval()
+			if (err != nil) != tt.err {
+				t.Fatalf("expect error %v, got %v", tt.err, err)
+			}
+			if interval != tt.expect {
+				t.Errorf("expect %v, got %v", tt.expect, interval)
+			}
+		})
+	}
+}
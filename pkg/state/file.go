@@ -0,0 +1,133 @@
+package state
+
+// Persisted form of the state, for inspection
+
+import (
+	"encoding/json"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/AirVantage/overlord/pkg/lookable"
+	"github.com/AirVantage/overlord/pkg/resource"
+	"github.com/AirVantage/overlord/pkg/set"
+)
+
+// Snapshot is the state of an iteration as saved on disk.
+type Snapshot struct {
+	Time time.Time `json:"time"`
+	// Sorted IPs of each group
+	Groups map[string][]string `json:"groups"`
+	// Members of the lookables knowing more than their IPs
+	Members   map[string][]lookable.Member `json:"members,omitempty"`
+	Templates map[string]Template          `json:"templates"`
+	Reloads   map[string]*Reload           `json:"reloads"`
+	// Hash of the rendered dest files which were rolled back
+	RolledBack map[string]string `json:"rolled_back,omitempty"`
+}
+
+// Template is a template resource of a Snapshot.
+type Template struct {
+	Dest    string    `json:"dest"`
+	ModTime time.Time `json:"mod_time"`
+}
+
+// Snapshot returns the snapshot of the state at the given time.
+func (s *State) Snapshot(now time.Time) *Snapshot {
+	snapshot := &Snapshot{
+		Time:       now,
+		Groups:     s.IPs(),
+		Members:    s.Members,
+		Templates:  make(map[string]Template),
+		Reloads:    s.Reloads,
+		RolledBack: s.RolledBack,
+	}
+	for src, resource := range s.Templates {
+		template := Template{Dest: resource.Dest}
+		if resource.SrcFSInfo != nil {
+			template.ModTime = resource.SrcFSInfo.ModTime()
+		}
+		snapshot.Templates[src] = template
+	}
+	return snapshot
+}
+
+// State returns the state of the snapshot. Its templates only know about their dest and modification time.
+func (s *Snapshot) State() *State {
+	state := New()
+	for group, ips := range s.Groups {
+		state.Ipsets[group] = set.New[string]()
+		for _, ip := range ips {
+			state.Ipsets[group].Add(ip)
+		}
+	}
+	for group, members := range s.Members {
+		state.Members[group] = members
+	}
+	for src, template := range s.Templates {
+		state.Templates[src] = &resource.Resource{
+			Src:       src,
+			Dest:      template.Dest,
+			SrcFSInfo: fileInfo{name: filepath.Base(src), modTime: template.ModTime},
+		}
+	}
+	for src, reload := range s.Reloads {
+		state.Reloads[src] = reload
+	}
+	for src, hash := range s.RolledBack {
+		state.RolledBack[src] = hash
+	}
+	return state
+}
+
+// fileInfo is the file information of a snapshot template.
+type fileInfo struct {
+	name    string
+	modTime time.Time
+}
+
+func (f fileInfo) Name() string       { return f.name }
+func (f fileInfo) Size() int64        { return 0 }
+func (f fileInfo) Mode() fs.FileMode  { return 0 }
+func (f fileInfo) ModTime() time.Time { return f.modTime }
+func (f fileInfo) IsDir() bool        { return false }
+func (f fileInfo) Sys() any           { return nil }
+
+// Save the snapshot as JSON in path, replacing the previous one atomically.
+func (s *Snapshot) Save(path string) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	encoder := json.NewEncoder(tmp)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(s); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// Load the snapshot saved in path.
+func Load(path string) (*Snapshot, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var snapshot Snapshot
+	if err := json.Unmarshal(content, &snapshot); err != nil {
+		return nil, err
+	}
+	return &snapshot, nil
+}
@@ -0,0 +1,53 @@
+package state
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/AirVantage/overlord/pkg/resource"
+	"github.com/AirVantage/overlord/pkg/set"
+)
+
+func TestSnapshotSaveLoad(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	s := New()
+	s.Ipsets["web"] = set.New[string]()
+	s.Ipsets["web"].Add("10.0.0.2")
+	s.Ipsets["web"].Add("10.0.0.1")
+	s.Templates["web.tmpl"] = &resource.Resource{Src: "web.tmpl", Dest: "/run/web.conf"}
+	s.Reloads["web.tmpl"] = &Reload{Time: now, ExitCode: 1, Duration: time.Second, Error: "exit status 1", RolledBack: true}
+	s.RolledBack["web.tmpl"] = "2c26b46b"
+
+	path := filepath.Join(t.TempDir(), "state", "state.json")
+	if err := s.Snapshot(now).Save(path); err != nil {
+		t.Fatal(err)
+	}
+	snapshot, err := Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expect := &Snapshot{
+		Time:       now,
+		Groups:     map[string][]string{"web": {"10.0.0.1", "10.0.0.2"}},
+		Templates:  map[string]Template{"web.tmpl": {Dest: "/run/web.conf"}},
+		Reloads:    s.Reloads,
+		RolledBack: map[string]string{"web.tmpl": "2c26b46b"},
+	}
+	if !reflect.DeepEqual(expect, snapshot) {
+		t.Errorf("expect %+v, got %+v", expect, snapshot)
+	}
+
+	restored := snapshot.State()
+	if !restored.Ipsets["web"].Has("10.0.0.1") || !restored.Ipsets["web"].Has("10.0.0.2") {
+		t.Errorf("expect restored IPs, got %v", restored.Ipsets)
+	}
+	if template := restored.Templates["web.tmpl"]; template.Dest != "/run/web.conf" || !template.SrcFSInfo.ModTime().IsZero() {
+		t.Errorf("expect restored template, got %+v", template)
+	}
+	if hash := restored.RolledBack["web.tmpl"]; hash != "2c26b46b" {
+		t.Errorf("expect restored rolled back hash, got %v", hash)
+	}
+}
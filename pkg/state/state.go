@@ -1,19 +1,84 @@
 package state
 
 import (
+	"sort"
+	"time"
+
+	"github.com/AirVantage/overlord/pkg/lookable"
 	"github.com/AirVantage/overlord/pkg/resource"
 	"github.com/AirVantage/overlord/pkg/set"
 )
 
 type State struct {
-	Ipsets    map[string]*set.Set[string]
+	Ipsets map[string]*set.Set[string]
+	// Sorted members of the lookables knowing more than the IPs of their members
+	Members   map[string][]lookable.Member
 	Templates map[string]*resource.Resource
+	// Outcome of the last reload of each resource, by template
+	Reloads map[string]*Reload
+	// Hash of the rendered dest file replaced by its previous version on rollback, by template,
+	// so that the same content is not applied again
+	RolledBack map[string]string
+}
+
+// Reload records the outcome of a resource reload.
+type Reload struct {
+	Time time.Time `json:"time"`
+	// Exit code and duration of the reload command
+	ExitCode int           `json:"exit_code"`
+	Duration time.Duration `json:"duration"`
+	// Error returned by the reload command, if any
+	Error string `json:"error,omitempty"`
+	// Error returned by the verify probe, if any
+	VerifyError string `json:"verify_error,omitempty"`
+	// Whether the verify probe ran and succeeded
+	Verified bool `json:"verified"`
+	// Whether the changes were applied through the HAProxy Runtime API instead of the reload command
+	Runtime bool `json:"runtime"`
+	// Whether the previous version of the resource was restored
+	RolledBack bool `json:"rolled_back"`
+}
+
+// Failed tells whether the reload command or its verification failed.
+func (r *Reload) Failed() bool {
+	return r.Error != "" || r.VerifyError != ""
 }
 
 // NewChanges return a pointer to an initialized Changes struct.
 func New() *State {
 	return &State{
-		Ipsets:    make(map[string]*set.Set[string]),
-		Templates: make(map[string]*resource.Resource),
+		Ipsets:     make(map[string]*set.Set[string]),
+		Members:    make(map[string][]lookable.Member),
+		Templates:  make(map[string]*resource.Resource),
+		Reloads:    make(map[string]*Reload),
+		RolledBack: make(map[string]string),
+	}
+}
+
+// IPs returns the sorted IPs of each group.
+func (s *State) IPs() map[string][]string {
+	ips := make(map[string][]string)
+	for group, ipsSet := range s.Ipsets {
+		ipsList := make([]string, 0, len(*ipsSet))
+		for ip := range *ipsSet {
+			ipsList = append(ipsList, ip)
+		}
+		sort.Strings(ipsList)
+		ips[group] = ipsList
+	}
+	return ips
+}
+
+// GroupMembers returns the sorted members of each group, with only their IP for the lookables
+// knowing nothing more.
+func (s *State) GroupMembers() map[string][]lookable.Member {
+	members := make(map[string][]lookable.Member)
+	for group, ips := range s.IPs() {
+		if groupMembers, exists := s.Members[group]; exists {
+			members[group] = groupMembers
+		} else {
+			members[group] = lookable.Members(ips)
+		}
 	}
+	return members
 }
@@ -0,0 +1,125 @@
+package systemd
+
+// Reload or restart systemd units over D-Bus
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/godbus/dbus/v5"
+)
+
+const (
+	destination = "org.freedesktop.systemd1"
+	objectPath  = dbus.ObjectPath("/org/freedesktop/systemd1")
+	manager     = "org.freedesktop.systemd1.Manager"
+)
+
+// Unit actions
+const (
+	Reload          = "reload"
+	Restart         = "restart"
+	ReloadOrRestart = "reload-or-restart"
+)
+
+// Manager methods for each unit action
+var methods = map[string]string{
+	Reload:          manager + ".ReloadUnit",
+	Restart:         manager + ".RestartUnit",
+	ReloadOrRestart: manager + ".ReloadOrRestartUnit",
+}
+
+// JobError is returned when a unit job did not complete successfully.
+type JobError struct {
+	Unit   string
+	Action string
+	// Job result reported by systemd: canceled, timeout, failed, dependency or skipped
+	Result string
+}
+
+func (e *JobError) Error() string {
+	return fmt.Sprintf("%s of unit %s: job %s", e.Action, e.Unit, e.Result)
+}
+
+// ValidAction tells whether action is a supported unit action.
+func ValidAction(action string) bool {
+	_, exists := methods[action]
+	return exists
+}
+
+// Client asks systemd to run unit jobs.
+type Client struct {
+	conn *dbus.Conn
+}
+
+// Connect to systemd through the system bus.
+func Connect(ctx context.Context) (*Client, error) {
+	conn, err := dbus.ConnectSystemBus(dbus.WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+	return New(conn), nil
+}
+
+// New returns a pointer to a Client using an established bus connection.
+func New(conn *dbus.Conn) *Client {
+	return &Client{conn: conn}
+}
+
+// Close the bus connection.
+func (c *Client) Close() error {
+	return c.conn.Close()
+}
+
+// Run action on unit and wait for the resulting job to complete.
+func (c *Client) Run(ctx context.Context, unit, action string) error {
+	method, exists := methods[action]
+	if !exists {
+		return fmt.Errorf("unsupported unit action %s", action)
+	}
+
+	// Listen to job completions before starting the job, not to miss it
+	err := c.conn.AddMatchSignalContext(ctx,
+		dbus.WithMatchObjectPath(objectPath),
+		dbus.WithMatchInterface(manager),
+		dbus.WithMatchMember("JobRemoved"))
+	if err != nil {
+		return err
+	}
+	signals := make(chan *dbus.Signal, 16)
+	c.conn.Signal(signals)
+	defer c.conn.RemoveSignal(signals)
+
+	obj := c.conn.Object(destination, objectPath)
+	if err := obj.CallWithContext(ctx, manager+".Subscribe", 0).Err; err != nil {
+		return err
+	}
+
+	var job dbus.ObjectPath
+	if err := obj.CallWithContext(ctx, method, 0, unit, "replace").Store(&job); err != nil {
+		return fmt.Errorf("%s of unit %s: %w", action, unit, err)
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("%s of unit %s: %w", action, unit, ctx.Err())
+		case signal, ok := <-signals:
+			if !ok {
+				return fmt.Errorf("%s of unit %s: connection closed", action, unit)
+			}
+			// JobRemoved(u id, o job, s unit, s result)
+			if signal.Name != manager+".JobRemoved" || len(signal.Body) != 4 {
+				continue
+			}
+			if path, _ := signal.Body[1].(dbus.ObjectPath); path != job {
+				continue
+			}
+			result, _ := signal.Body[3].(string)
+			if result != "done" {
+				return &JobError{Unit: unit, Action: action, Result: result}
+			}
+			return nil
+		}
+	}
+}
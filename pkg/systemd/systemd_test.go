@@ -0,0 +1,134 @@
+package systemd
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/godbus/dbus/v5"
+)
+
+// Start a private bus, skipping the test when dbus-daemon is not available.
+func privateBus(t *testing.T) string {
+	daemon, err := exec.LookPath("dbus-daemon")
+	if err != nil {
+		t.Skip("dbus-daemon not available")
+	}
+
+	socket := filepath.Join(t.TempDir(), "bus.sock")
+	cmd := exec.Command(daemon, "--session", "--address=unix:path="+socket, "--nofork", "--print-address")
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		cmd.Process.Kill()
+		cmd.Wait()
+	})
+
+	address, err := bufio.NewReader(stdout).ReadString('\n')
+	if err != nil {
+		t.Fatal(err)
+	}
+	return strings.TrimSpace(address)
+}
+
+// fakeManager implements the systemd Manager methods used by Client.
+type fakeManager struct {
+	conn    *dbus.Conn
+	results map[string]string
+	jobs    atomic.Uint32
+}
+
+func (m *fakeManager) Subscribe() *dbus.Error {
+	return nil
+}
+
+func (m *fakeManager) ReloadUnit(unit, mode string) (dbus.ObjectPath, *dbus.Error) {
+	result, exists := m.results[unit]
+	if !exists {
+		return "", dbus.NewError("org.freedesktop.systemd1.NoSuchUnit", []interface{}{"Unit " + unit + " not found."})
+	}
+
+	id := m.jobs.Add(1)
+	job := dbus.ObjectPath("/org/freedesktop/systemd1/job/" + strconv.Itoa(int(id)))
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		m.conn.Emit(objectPath, manager+".JobRemoved", id, job, unit, result)
+	}()
+	return job, nil
+}
+
+func TestRun(t *testing.T) {
+	address := privateBus(t)
+
+	service, err := dbus.Connect(address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer service.Close()
+
+	fake := &fakeManager{
+		conn: service,
+		results: map[string]string{
+			"haproxy.service": "done",
+			"broken.service":  "failed",
+		},
+	}
+	if err := service.Export(fake, objectPath, manager); err != nil {
+		t.Fatal(err)
+	}
+	if reply, err := service.RequestName(destination, dbus.NameFlagDoNotQueue); err != nil || reply != dbus.RequestNameReplyPrimaryOwner {
+		t.Fatalf("unable to own %s: %v", destination, err)
+	}
+
+	conn, err := dbus.Connect(address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := New(conn)
+	defer client.Close()
+
+	cases := []struct {
+		unit   string
+		action string
+		result string
+		err    bool
+	}{
+		/* Successful reload */
+		{unit: "haproxy.service", action: Reload},
+		/* Failed reload */
+		{unit: "broken.service", action: Reload, result: "failed", err: true},
+		/* Unknown unit */
+		{unit: "missing.service", action: Reload, err: true},
+		/* Unknown action */
+		{unit: "haproxy.service", action: "stop", err: true},
+	}
+
+	for i, tt := range cases {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+
+			err := client.Run(ctx, tt.unit, tt.action)
+			if (err != nil) != tt.err {
+				t.Fatalf("expect error %v, got %v", tt.err, err)
+			}
+
+			var jobErr *JobError
+			if tt.result != "" && (!errors.As(err, &jobErr) || jobErr.Result != tt.result) {
+				t.Errorf("expect job result %v, got %v", tt.result, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,141 @@
+package verify
+
+// Post-reload health probes
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/AirVantage/overlord/pkg/process"
+)
+
+const (
+	defaultTimeout  = 5 * time.Second
+	defaultInterval = time.Second
+)
+
+// Probe checks that a managed application is healthy after its configuration was reloaded.
+// Exactly one of HTTP, TCP or Cmd must be set.
+type Probe struct {
+	// URL to GET, any status code below 400 is a success
+	HTTP string `toml:"http"`
+	// Address to connect to, as host:port
+	TCP string `toml:"tcp"`
+	// Command run through bash, a zero exit code is a success
+	Cmd string `toml:"cmd"`
+	// Maximum duration of each attempt
+	Timeout time.Duration `toml:"timeout"`
+	// Number of attempts after the first failure
+	Retries int `toml:"retries"`
+	// Delay between attempts
+	Interval time.Duration `toml:"interval"`
+}
+
+func (p *Probe) String() string {
+	switch {
+	case p.HTTP != "":
+		return "http " + p.HTTP
+	case p.TCP != "":
+		return "tcp " + p.TCP
+	default:
+		return "cmd " + p.Cmd
+	}
+}
+
+// Validate the probe configuration.
+func (p *Probe) Validate() error {
+	set := 0
+	for _, target := range []string{p.HTTP, p.TCP, p.Cmd} {
+		if target != "" {
+			set++
+		}
+	}
+	if set != 1 {
+		return errors.New("verify requires exactly one of http, tcp or cmd")
+	}
+	return nil
+}
+
+// Clock waits between the attempts of a probe.
+type Clock interface {
+	After(d time.Duration) <-chan time.Time
+}
+
+// Run the probe until it succeeds or all the attempts failed, in which case the last error is returned.
+// Attempts are spaced out on clock, the one of the operating system when nil.
+func (p *Probe) Run(ctx context.Context, clock Clock) error {
+	after := time.After
+	if clock != nil {
+		after = clock.After
+	}
+
+	interval := p.Interval
+	if interval <= 0 {
+		interval = defaultInterval
+	}
+
+	var err error
+	for attempt := 0; attempt <= p.Retries; attempt++ {
+		if attempt > 0 {
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-after(interval):
+			}
+		}
+
+		if err = p.try(ctx); err == nil {
+			return nil
+		}
+	}
+	return fmt.Errorf("%s: %w", p, err)
+}
+
+// Run a single attempt of the probe.
+func (p *Probe) try(ctx context.Context) error {
+	timeout := p.Timeout
+	if timeout <= 0 {
+		timeout = defaultTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	switch {
+	case p.HTTP != "":
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, p.HTTP, nil)
+		if err != nil {
+			return err
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			return err
+		}
+		resp.Body.Close()
+		if resp.StatusCode >= 400 {
+			return fmt.Errorf("unexpected status %s", resp.Status)
+		}
+		return nil
+
+	case p.TCP != "":
+		var d net.Dialer
+		conn, err := d.DialContext(ctx, "tcp", p.TCP)
+		if err != nil {
+			return err
+		}
+		return conn.Close()
+
+	case p.Cmd != "":
+		cmd := process.Command(ctx, "bash", "-c", p.Cmd)
+		if err := cmd.Start(); err != nil {
+			return err
+		}
+		return process.Wait(ctx, cmd)
+
+	default:
+		return errors.New("no http, tcp or cmd probe configured")
+	}
+}
@@ -0,0 +1,149 @@
+package verify
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestProbeRun(t *testing.T) {
+
+	var calls atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/flaky":
+			if calls.Add(1) == 1 {
+				w.WriteHeader(http.StatusServiceUnavailable)
+			}
+		case "/down":
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}))
+	defer server.Close()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	closed := listener.Addr().String()
+	listener.Close()
+
+	cases := []struct {
+		probe  Probe
+		expect bool
+		// Waits between attempts
+		waits []time.Duration
+	}{
+		/* HTTP success */
+		{
+			probe:  Probe{HTTP: server.URL + "/health"},
+			expect: true,
+		},
+		/* HTTP error status */
+		{
+			probe:  Probe{HTTP: server.URL + "/down", Retries: 2, Interval: time.Hour},
+			expect: false,
+			waits:  []time.Duration{time.Hour, time.Hour},
+		},
+		/* HTTP success after a retry */
+		{
+			probe:  Probe{HTTP: server.URL + "/flaky", Retries: 3},
+			expect: true,
+			waits:  []time.Duration{defaultInterval},
+		},
+		/* TCP success */
+		{
+			probe:  Probe{TCP: server.Listener.Addr().String()},
+			expect: true,
+		},
+		/* TCP connection refused */
+		{
+			probe:  Probe{TCP: closed},
+			expect: false,
+		},
+		/* Command success */
+		{
+			probe:  Probe{Cmd: "exit 0"},
+			expect: true,
+		},
+		/* Command timeout, killing the children of the command */
+		{
+			probe:  Probe{Cmd: "sleep 30 & wait", Timeout: 10 * time.Millisecond},
+			expect: false,
+		},
+		/* Nothing configured */
+		{
+			probe:  Probe{},
+			expect: false,
+		},
+	}
+
+	for i, tt := range cases {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			clock := &clock{}
+			err := tt.probe.Run(context.TODO(), clock)
+			if (err == nil) != tt.expect {
+				t.Errorf("expect success %v, got %v", tt.expect, err)
+			}
+			if !reflect.DeepEqual(clock.waits, tt.waits) {
+				t.Errorf("expect waits %v, got %v", tt.waits, clock.waits)
+			}
+		})
+	}
+}
+
+// clock records the waits, which are over right away.
+type clock struct {
+	waits []time.Duration
+}
+
+func (c *clock) After(d time.Duration) <-chan time.Time {
+	c.waits = append(c.waits, d)
+	ready := make(chan time.Time, 1)
+	ready <- time.Now()
+	return ready
+}
+
+func TestProbeValidate(t *testing.T) {
+
+	cases := []struct {
+		probe  Probe
+		expect bool
+	}{
+		/* HTTP probe */
+		{
+			probe:  Probe{HTTP: "http://127.0.0.1/health"},
+			expect: true,
+		},
+		/* Command probe */
+		{
+			probe:  Probe{Cmd: "true", Retries: 2},
+			expect: true,
+		},
+		/* No target */
+		{
+			probe:  Probe{Timeout: time.Second},
+			expect: false,
+		},
+		/* Several targets */
+		{
+			probe:  Probe{HTTP: "http://127.0.0.1/health", TCP: "127.0.0.1:80"},
+			expect: false,
+		},
+	}
+
+	for i, tt := range cases {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			err := tt.probe.Validate()
+			if (err == nil) != tt.expect {
+				t.Errorf("expect valid %v, got %v", tt.expect, err)
+			}
+		})
+	}
+}
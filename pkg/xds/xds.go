@@ -0,0 +1,147 @@
+package xds
+
+// Envoy Endpoint Discovery Service, serving each lookable as a cluster
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net"
+	"sort"
+	"strconv"
+	"sync"
+
+	"github.com/AirVantage/overlord/pkg/lookable"
+	core "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+	endpoint "github.com/envoyproxy/go-control-plane/envoy/config/endpoint/v3"
+	discoverygrpc "github.com/envoyproxy/go-control-plane/envoy/service/discovery/v3"
+	endpointservice "github.com/envoyproxy/go-control-plane/envoy/service/endpoint/v3"
+	"github.com/envoyproxy/go-control-plane/pkg/cache/types"
+	"github.com/envoyproxy/go-control-plane/pkg/cache/v3"
+	"github.com/envoyproxy/go-control-plane/pkg/resource/v3"
+	"github.com/envoyproxy/go-control-plane/pkg/server/v3"
+	"google.golang.org/grpc"
+)
+
+// All Envoy nodes share the same snapshot
+const nodeID = "overlord"
+
+// Server serves the members of each lookable as an Envoy EDS cluster, named after the lookable.
+type Server struct {
+	// Port of the cluster endpoints whose member has no port
+	Port uint32
+
+	cache   cache.SnapshotCache
+	mu      sync.Mutex
+	version uint64
+}
+
+// New returns a pointer to a Server publishing endpoints on the given port by default.
+func New(port uint32) *Server {
+	return &Server{
+		Port:  port,
+		cache: cache.NewSnapshotCache(false, constantHash{}, logger{}),
+	}
+}
+
+// Serve EDS and ADS over gRPC on listener until ctx is done.
+func (s *Server) Serve(ctx context.Context, listener net.Listener) error {
+	grpcServer := grpc.NewServer()
+	xdsServer := server.NewServer(ctx, s.cache, nil)
+	endpointservice.RegisterEndpointDiscoveryServiceServer(grpcServer, xdsServer)
+	discoverygrpc.RegisterAggregatedDiscoveryServiceServer(grpcServer, xdsServer)
+
+	go func() {
+		<-ctx.Done()
+		grpcServer.Stop()
+	}()
+
+	slog.Info("Serving Envoy endpoint discovery", "address", listener.Addr().String())
+	return grpcServer.Serve(listener)
+}
+
+// Update publishes a new snapshot with one cluster per group, listing its members.
+func (s *Server) Update(ctx context.Context, members map[string][]lookable.Member) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	groups := make([]string, 0, len(members))
+	for group := range members {
+		groups = append(groups, group)
+	}
+	sort.Strings(groups)
+
+	assignments := make([]types.Resource, 0, len(groups))
+	for _, group := range groups {
+		assignments = append(assignments, s.loadAssignment(group, members[group]))
+	}
+
+	s.version++
+	snapshot, err := cache.NewSnapshot(strconv.FormatUint(s.version, 10), map[resource.Type][]types.Resource{
+		resource.EndpointType: assignments,
+	})
+	if err != nil {
+		return err
+	}
+
+	slog.Debug("Publishing Envoy endpoints", "version", s.version, "clusters", len(assignments))
+	return s.cache.SetSnapshot(ctx, nodeID, snapshot)
+}
+
+// Cluster endpoints of a group, on the port of each member or the one of the server.
+func (s *Server) loadAssignment(group string, members []lookable.Member) *endpoint.ClusterLoadAssignment {
+	endpoints := make([]*endpoint.LbEndpoint, 0, len(members))
+	for _, member := range members {
+		port := s.Port
+		if member.Port > 0 {
+			port = uint32(member.Port)
+		}
+		endpoints = append(endpoints, &endpoint.LbEndpoint{
+			HostIdentifier: &endpoint.LbEndpoint_Endpoint{
+				Endpoint: &endpoint.Endpoint{
+					Address: &core.Address{
+						Address: &core.Address_SocketAddress{
+							SocketAddress: &core.SocketAddress{
+								Protocol: core.SocketAddress_TCP,
+								Address:  member.IP,
+								PortSpecifier: &core.SocketAddress_PortValue{
+									PortValue: port,
+								},
+							},
+						},
+					},
+				},
+			},
+		})
+	}
+
+	return &endpoint.ClusterLoadAssignment{
+		ClusterName: group,
+		Endpoints: []*endpoint.LocalityLbEndpoints{
+			{LbEndpoints: endpoints},
+		},
+	}
+}
+
+// constantHash gives the same snapshot to all the Envoy nodes.
+type constantHash struct{}
+
+func (constantHash) ID(*core.Node) string {
+	return nodeID
+}
+
+// logger forwards the control plane logs to slog.
+type logger struct{}
+
+func (logger) Debugf(format string, args ...interface{}) {
+	slog.Debug(fmt.Sprintf(format, args...))
+}
+func (logger) Infof(format string, args ...interface{}) {
+	slog.Debug(fmt.Sprintf(format, args...))
+}
+func (logger) Warnf(format string, args ...interface{}) {
+	slog.Warn(fmt.Sprintf(format, args...))
+}
+func (logger) Errorf(format string, args ...interface{}) {
+	slog.Error(fmt.Sprintf(format, args...))
+}
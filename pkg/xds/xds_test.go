@@ -0,0 +1,84 @@
+package xds
+
+import (
+	"context"
+	"net"
+	"slices"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/AirVantage/overlord/pkg/lookable"
+
+	endpoint "github.com/envoyproxy/go-control-plane/envoy/config/endpoint/v3"
+	discovery "github.com/envoyproxy/go-control-plane/envoy/service/discovery/v3"
+	endpointservice "github.com/envoyproxy/go-control-plane/envoy/service/endpoint/v3"
+	"github.com/envoyproxy/go-control-plane/pkg/resource/v3"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+)
+
+func TestStreamEndpoints(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	server := New(8080)
+	go server.Serve(ctx, listener)
+
+	err = server.Update(ctx, map[string][]lookable.Member{
+		"my-asg":   {{IP: "10.0.0.1"}, {IP: "10.0.0.2", Port: 9090}},
+		"my-other": {{IP: "10.0.1.1"}},
+	})
+	if err != nil {
+		t.Fatalf("expect no error, got %v", err)
+	}
+
+	conn, err := grpc.NewClient(listener.Addr().String(), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	stream, err := endpointservice.NewEndpointDiscoveryServiceClient(conn).StreamEndpoints(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = stream.Send(&discovery.DiscoveryRequest{
+		TypeUrl:       resource.EndpointType,
+		ResourceNames: []string{"my-asg"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	response, err := stream.Recv()
+	if err != nil {
+		t.Fatalf("expect no error, got %v", err)
+	}
+	if len(response.Resources) != 1 {
+		t.Fatalf("expect 1 cluster, got %d", len(response.Resources))
+	}
+
+	var assignment endpoint.ClusterLoadAssignment
+	if err := response.Resources[0].UnmarshalTo(&assignment); err != nil {
+		t.Fatal(err)
+	}
+	if assignment.ClusterName != "my-asg" {
+		t.Errorf("expect cluster my-asg, got %v", assignment.ClusterName)
+	}
+
+	// Members without port get the one of the server
+	var addresses []string
+	for _, lbEndpoint := range assignment.Endpoints[0].LbEndpoints {
+		socket := lbEndpoint.GetEndpoint().Address.GetSocketAddress()
+		addresses = append(addresses, net.JoinHostPort(socket.Address, strconv.Itoa(int(socket.GetPortValue()))))
+	}
+	if expect := []string{"10.0.0.1:8080", "10.0.0.2:9090"}; !slices.Equal(addresses, expect) {
+		t.Errorf("expect %v, got %v", expect, addresses)
+	}
+}